@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// optionLetters labels of the answer options
+var optionLetters = []string{"A", "B", "C", "D"}
+
+// submitOption option of the button which submits the selection of a multi
+// choice question
+const submitOption = -1
+
+// answerButton endpoint of the answer buttons, the callback data is
+// "campaign|session|question index|option"
+var answerButton = tb.InlineButton{Unique: "answer"}
+
+// toggleButton endpoint of the option buttons of multi choice questions,
+// the callback data is the same as the answer buttons
+var toggleButton = tb.InlineButton{Unique: "toggle"}
+
+// AnswerData what an answer button carries besides the campaign
+type AnswerData struct {
+	Session string
+	Index   int
+	Option  int
+}
+
+func parseAnswerData(data string) (AnswerData, error) {
+	fields := strings.Split(data, "|")
+	if len(fields) != 3 {
+		return AnswerData{}, fmt.Errorf("invalid answer data: %s", data)
+	}
+	index, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return AnswerData{}, err
+	}
+	option, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return AnswerData{}, err
+	}
+	return AnswerData{
+		Session: fields[0],
+		Index:   index,
+		Option:  option,
+	}, nil
+}
+
+// questionKeys inline keyboard of the question, one button for each option,
+// the buttons of a multi choice question toggle the option and a last button
+// submits the selection. Questions answered by text have no keyboard
+func (b Bot) questionKeys(current Question, question QuizQuestion) [][]tb.InlineButton {
+	if question.typed() {
+		return nil
+	}
+	unique := answerButton.Unique
+	if question.Type == TypeMulti {
+		unique = toggleButton.Unique
+	}
+	index := strconv.Itoa(current.CurrentQuestion)
+	keys := [][]tb.InlineButton{}
+	row := []tb.InlineButton{}
+	for key := range question.Options {
+		text := optionLetters[key]
+		if question.Type == TypeMulti && current.selected(key) {
+			text = "✅ " + text
+		}
+		row = append(row, tb.InlineButton{
+			Unique: unique,
+			Text:   text,
+			Data:   b.callbackData(current.Session, index, strconv.Itoa(key)),
+		})
+		if key%2 == 1 {
+			keys = append(keys, row)
+			row = []tb.InlineButton{}
+		}
+	}
+	if len(row) > 0 {
+		keys = append(keys, row)
+	}
+	if question.Type == TypeMulti {
+		keys = append(keys, []tb.InlineButton{
+			{
+				Unique: answerButton.Unique,
+				Text:   "Xong",
+				Data:   b.callbackData(current.Session, index, strconv.Itoa(submitOption)),
+			},
+		})
+	}
+	return keys
+}
+
+// callbackData data of an inline button of the campaign, the router finds
+// the campaign from the first field
+func (b Bot) callbackData(fields ...string) string {
+	return strings.Join(append([]string{b.campaign.ID}, fields...), "|")
+}
+
+// callbackMessage message of the user who pressed the button, so the
+// callbacks can go through the same handlers as the messages
+func callbackMessage(c *tb.Callback) *tb.Message {
+	return &tb.Message{
+		ID:     c.Message.ID,
+		Sender: c.Sender,
+		Chat:   c.Message.Chat,
+	}
+}
+
+// shownQuestion the question at a position of the attempt with the options
+// in the order the user sees them
+func (b Bot) shownQuestion(current Question, position int) QuizQuestion {
+	question := b.questionAt(current, position)
+	if position >= len(current.Permutations) || len(current.Permutations[position]) != len(question.Options) {
+		return question
+	}
+	options := []string{}
+	for _, original := range current.Permutations[position] {
+		options = append(options, question.Options[original])
+	}
+	question.Options = options
+	return question
+}
+
+// shuffleOptions a random order of the options of every question, nil if
+// the options are shown in the file order
+func (b Bot) shuffleOptions(questions Questions, questionIDs []string) [][]int {
+	if !b.campaign.Quiz.ShuffleOptions {
+		return nil
+	}
+	permutations := [][]int{}
+	for _, id := range questionIDs {
+		question, _ := questions.find(id)
+		permutations = append(permutations, rand.Perm(len(question.Options)))
+	}
+	return permutations
+}
+
+// optionsText letters of the options, separated by commas
+func optionsText(options []int) string {
+	letters := []string{}
+	for _, option := range options {
+		letters = append(letters, optionLetters[option])
+	}
+	return strings.Join(letters, ", ")
+}
+
+// markdownEscaper escape the characters telegram markdown would parse, a
+// single one of them makes telegram reject the whole message
+var markdownEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[")
+
+// escapeMarkdown text of the questions or of the users sent as it is
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+func questionText(index int, question QuizQuestion) string {
+	message := fmt.Sprintf("%d. %s \n\n", index+1, escapeMarkdown(question.Question))
+	for key, option := range question.Options {
+		message += fmt.Sprintf("%s. %s \n", optionLetters[key], escapeMarkdown(option))
+	}
+	switch question.Type {
+	case TypeMulti:
+		message += "\nChọn tất cả các đáp án đúng rồi bấm Xong."
+	case TypeText:
+		message += "\nCon hãy gõ câu trả lời."
+	case TypeNumeric:
+		message += "\nCon hãy gõ một con số."
+	}
+	return message
+}
+
+// sendQuestion send the question, with its photo if it has one
+func (b Bot) sendQuestion(to *tb.User, current Question, question QuizQuestion, deadline int64) (*tb.Message, error) {
+	text := questionText(current.CurrentQuestion, question)
+	if deadline > 0 {
+		text += countdownText(deadline)
+	}
+	options := &tb.SendOptions{
+		DisableWebPagePreview: true,
+		ParseMode:             tb.ModeMarkdown,
+	}
+	if keys := b.questionKeys(current, question); keys != nil {
+		options.ReplyMarkup = &tb.ReplyMarkup{
+			InlineKeyboard: keys,
+		}
+	}
+	if question.Photo == "" {
+		return b.bot.Send(to, text, options)
+	}
+	return b.bot.Send(to, &tb.Photo{File: photoFile(question.Photo), Caption: text}, options)
+}
+
+// editQuestion replace the text and keyboard of the question message sent to
+// the user, a nil keyboard removes it
+func (b Bot) editQuestion(current Question, question QuizQuestion, text string, keys [][]tb.InlineButton) error {
+	message := tb.StoredMessage{
+		MessageID: strconv.Itoa(current.MessageID),
+		ChatID:    current.ID,
+	}
+	if question.Photo == "" {
+		options := &tb.SendOptions{
+			DisableWebPagePreview: true,
+			ParseMode:             tb.ModeMarkdown,
+		}
+		if keys != nil {
+			options.ReplyMarkup = &tb.ReplyMarkup{
+				InlineKeyboard: keys,
+			}
+		}
+		_, err := b.bot.Edit(message, text, options)
+		return err
+	}
+
+	// telebot can't edit the caption together with the keyboard
+	params := map[string]string{
+		"chat_id":    strconv.FormatInt(current.ID, 10),
+		"message_id": message.MessageID,
+		"caption":    text,
+		"parse_mode": string(tb.ModeMarkdown),
+	}
+	if keys != nil {
+		markup, err := json.Marshal(tb.ReplyMarkup{InlineKeyboard: rawButtons(keys)})
+		if err != nil {
+			return err
+		}
+		params["reply_markup"] = string(markup)
+	}
+	data, err := b.bot.Raw("editMessageCaption", params)
+	if err != nil {
+		return err
+	}
+	var response struct {
+		Ok          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return err
+	}
+	if !response.Ok {
+		return fmt.Errorf("cannot edit caption: %s", response.Description)
+	}
+	return nil
+}
+
+// rawButtons encode the endpoint of the buttons into their data like telebot
+// does when it sends a keyboard
+func rawButtons(keys [][]tb.InlineButton) [][]tb.InlineButton {
+	result := [][]tb.InlineButton{}
+	for _, row := range keys {
+		rawRow := []tb.InlineButton{}
+		for _, key := range row {
+			if key.Unique != "" {
+				key.Data = "\f" + key.Unique + "|" + key.Data
+				key.Unique = ""
+			}
+			rawRow = append(rawRow, key)
+		}
+		result = append(result, rawRow)
+	}
+	return result
+}
+
+// callbackQuestion the current question of the user if the button belongs to
+// it, otherwise the callback is answered as stale
+func (b Bot) callbackQuestion(c *tb.Callback) (Question, QuizQuestion, AnswerData, bool) {
+	data, err := parseAnswerData(c.Data)
+	if err != nil {
+		log.Printf("Cannot parse answer: %s", err.Error())
+		return Question{}, QuizQuestion{}, data, false
+	}
+	currentQuestion, err := b.storage.GetCurrentQuestion(c.Message.Chat.ID)
+	if err != nil || currentQuestion.Session != data.Session || currentQuestion.CurrentQuestion != data.Index {
+		b.bot.Respond(c, &tb.CallbackResponse{
+			Text: "Câu hỏi này đã hết hạn.",
+		})
+		return currentQuestion, QuizQuestion{}, data, false
+	}
+	if currentQuestion.Deadline > 0 && time.Now().Unix() >= currentQuestion.Deadline {
+		b.bot.Respond(c, &tb.CallbackResponse{
+			Text: "Hết giờ trả lời câu hỏi này rồi.",
+		})
+		return currentQuestion, QuizQuestion{}, data, false
+	}
+	question := b.shownQuestion(currentQuestion, data.Index)
+	if data.Option != submitOption && (data.Option < 0 || data.Option >= len(question.Options)) {
+		b.bot.Respond(c, &tb.CallbackResponse{
+			Text: "Câu hỏi không có phương án con chọn.",
+		})
+		return currentQuestion, question, data, false
+	}
+	return currentQuestion, question, data, true
+}
+
+func (b Bot) handleAnswerCallback(c *tb.Callback) {
+	currentQuestion, question, data, ok := b.callbackQuestion(c)
+	if !ok {
+		return
+	}
+	m := callbackMessage(c)
+	if !b.checkPhase(m, PhaseQuiz) {
+		b.bot.Respond(c)
+		return
+	}
+	// the buttons are of the options as shown, the answers are of the options in the file
+	shown := []int{data.Option}
+	if data.Option == submitOption {
+		shown = currentQuestion.Selected
+	}
+	options := currentQuestion.originalOptions(data.Index, shown)
+	var answer AttemptAnswer
+	switch {
+	case question.Type == TypeMulti && data.Option == submitOption:
+		answer = b.attemptAnswer(currentQuestion, question.gradeOptions(options))
+	case question.Type == TypeSingle && data.Option != submitOption:
+		answer = b.attemptAnswer(currentQuestion, question.gradeOption(options[0]))
+	default:
+		b.bot.Respond(c)
+		return
+	}
+	answer.Options = options
+	b.bot.Respond(c)
+	text := questionText(data.Index, question) + fmt.Sprintf("\nCon đã chọn: %s", optionsText(shown))
+	if err := b.editQuestion(currentQuestion, question, text, nil); err != nil {
+		log.Printf("Cannot edit question message: %s", err.Error())
+	}
+	b.handleAnswer(m, currentQuestion, answer)
+}
+
+// handleToggleCallback select or unselect an option of a multi choice question
+func (b Bot) handleToggleCallback(c *tb.Callback) {
+	currentQuestion, question, data, ok := b.callbackQuestion(c)
+	if !ok {
+		return
+	}
+	if question.Type != TypeMulti || data.Option == submitOption {
+		b.bot.Respond(c)
+		return
+	}
+	currentQuestion, err := b.storage.ToggleOption(currentQuestion.ID, data.Index, data.Option)
+	if err != nil {
+		log.Printf("Cannot toggle option: %s", err.Error())
+		b.bot.Respond(c)
+		return
+	}
+	b.bot.Respond(c)
+	text := questionText(data.Index, question)
+	if currentQuestion.Deadline > 0 {
+		text += countdownText(currentQuestion.Deadline)
+	}
+	if err := b.editQuestion(currentQuestion, question, text, b.questionKeys(currentQuestion, question)); err != nil {
+		log.Printf("Cannot edit question message: %s", err.Error())
+	}
+}
+
+// handleTextAnswer grade the text typed for a free text or numeric question
+func (b Bot) handleTextAnswer(m *tb.Message) {
+	currentQuestion, err := b.storage.GetCurrentQuestion(m.Chat.ID)
+	if err != nil || currentQuestion.CurrentQuestion >= currentQuestion.count() {
+		b.updateState(m, StateIdle, "")
+		b.handleDefault(m)
+		return
+	}
+	question := b.shownQuestion(currentQuestion, currentQuestion.CurrentQuestion)
+	if !question.typed() {
+		b.updateState(m, StateIdle, "")
+		b.handleDefault(m)
+		return
+	}
+	if !b.checkPhase(m, PhaseQuiz) {
+		return
+	}
+	if currentQuestion.Deadline > 0 && time.Now().Unix() >= currentQuestion.Deadline {
+		b.bot.Reply(m, "Hết giờ trả lời câu hỏi này rồi.")
+		return
+	}
+	answer := strings.TrimSpace(m.Text)
+	text := questionText(currentQuestion.CurrentQuestion, question) + fmt.Sprintf("\nCon đã trả lời: %s", escapeMarkdown(answer))
+	if err := b.editQuestion(currentQuestion, question, text, nil); err != nil {
+		log.Printf("Cannot edit question message: %s", err.Error())
+	}
+	b.updateState(m, StateIdle, "")
+	attemptAnswer := b.attemptAnswer(currentQuestion, question.gradeText(answer))
+	attemptAnswer.Text = answer
+	b.handleAnswer(m, currentQuestion, attemptAnswer)
+}
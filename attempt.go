@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// attemptsShown latest attempts shown by /attempts, a message can't hold them all
+const attemptsShown = 5
+
+// attemptTimeFormat how the attempt times are shown
+const attemptTimeFormat = "02/01 15:04:05"
+
+// attemptsLeft attempts the user can still start, -1 if there is no limit
+func (q QuizConfig) attemptsLeft(attempts []Attempt) int {
+	if q.MaxAttempts == 0 {
+		return -1
+	}
+	if len(attempts) >= q.MaxAttempts {
+		return 0
+	}
+	return q.MaxAttempts - len(attempts)
+}
+
+// cooldownUntil when the user can start the next attempt, the cooldown runs
+// from the end of the latest attempt or from its start if it was abandoned
+func (q QuizConfig) cooldownUntil(attempts []Attempt) int64 {
+	if q.Cooldown == 0 || len(attempts) == 0 {
+		return 0
+	}
+	latest := attempts[len(attempts)-1]
+	from := latest.FinishedAt
+	if from == 0 {
+		from = latest.StartedAt
+	}
+	return from + int64(q.Cooldown)
+}
+
+// waitText how long the user has to wait, in words
+func waitText(seconds int64) string {
+	switch {
+	case seconds >= 3600:
+		return fmt.Sprintf("%d giờ %d phút", seconds/3600, seconds%3600/60)
+	case seconds >= 60:
+		return fmt.Sprintf("%d phút %d giây", seconds/60, seconds%60)
+	default:
+		return fmt.Sprintf("%d giây", seconds)
+	}
+}
+
+// checkAttempts check if the user may start another attempt, otherwise reply why not
+func (b Bot) checkAttempts(m *tb.Message) bool {
+	attempts, err := b.storage.GetAttempts(m.Sender.ID)
+	if err != nil {
+		log.Printf("Cannot get attempts of %d: %s", m.Sender.ID, err.Error())
+		b.bot.Reply(m, "Bụt đang bận, con thử lại sau nhé.")
+		return false
+	}
+	if b.campaign.Quiz.attemptsLeft(attempts) == 0 {
+		b.bot.Reply(m, fmt.Sprintf("Con đã dùng hết %d lượt trả lời câu hỏi của chương trình rồi.", b.campaign.Quiz.MaxAttempts))
+		return false
+	}
+	if wait := b.campaign.Quiz.cooldownUntil(attempts) - time.Now().Unix(); wait > 0 {
+		b.bot.Reply(m, fmt.Sprintf("Con cần nghỉ thêm %s nữa mới được trả lời lại.", waitText(wait)))
+		return false
+	}
+	return true
+}
+
+// attemptsText what /me tells about the attempts left
+func (b Bot) attemptsText(userID int) string {
+	attempts, err := b.storage.GetAttempts(userID)
+	if err != nil {
+		log.Printf("Cannot get attempts of %d: %s", userID, err.Error())
+		return ""
+	}
+	message := ""
+	left := b.campaign.Quiz.attemptsLeft(attempts)
+	if left >= 0 {
+		message += fmt.Sprintf("Con đã làm %d/%d lượt, còn %d lượt.\n", len(attempts), b.campaign.Quiz.MaxAttempts, left)
+	}
+	if wait := b.campaign.Quiz.cooldownUntil(attempts) - time.Now().Unix(); left != 0 && wait > 0 {
+		message += fmt.Sprintf("Con có thể làm lại sau %s nữa.\n", waitText(wait))
+	}
+	return message
+}
+
+// shownOptions chosen options in the order they were shown
+func (a AttemptAnswer) shownOptions() []int {
+	shown := []int{}
+	for _, option := range a.Options {
+		for index, original := range a.Permutation {
+			if original == option {
+				shown = append(shown, index)
+			}
+		}
+	}
+	return shown
+}
+
+func (a Attempt) String() string {
+	correct := 0
+	points := 0.0
+	for _, answer := range a.Answers {
+		if answer.Correct {
+			correct++
+		}
+		points += answer.Points
+	}
+	message := fmt.Sprintf("Bắt đầu %s", time.Unix(a.StartedAt, 0).Format(attemptTimeFormat))
+	if a.FinishedAt > 0 {
+		message += fmt.Sprintf(", xong %s", time.Unix(a.FinishedAt, 0).Format(attemptTimeFormat))
+	} else {
+		message += ", chưa xong"
+	}
+	message += fmt.Sprintf(": đúng %d/%d câu, %g điểm\n", correct, len(a.Questions), points)
+	for _, answer := range a.Answers {
+		message += fmt.Sprintf("  %d. Câu %s: ", answer.Index+1, answer.Question)
+		switch {
+		case answer.TimedOut:
+			message += "hết giờ"
+		case answer.Text != "":
+			message += fmt.Sprintf("trả lời \"%s\"", answer.Text)
+		case len(answer.Permutation) > 0:
+			message += fmt.Sprintf("chọn %s (hiện là %s)", optionsText(answer.Options), optionsText(answer.shownOptions()))
+		default:
+			message += fmt.Sprintf("chọn %s", optionsText(answer.Options))
+		}
+		message += fmt.Sprintf(", %g/%g điểm", answer.Points, answer.MaxPoints)
+		if !answer.TimedOut && answer.SentAt > 0 {
+			message += fmt.Sprintf(", sau %d giây", answer.AnsweredAt-answer.SentAt)
+		}
+		message += "\n"
+	}
+	return message
+}
+
+// handleAttempts /attempts [user id] show every attempt of the user with the answers given
+func (b Bot) handleAttempts(m *tb.Message) {
+	if !b.checkAdmin(m) {
+		return
+	}
+	userID, err := strconv.Atoi(strings.TrimSpace(m.Payload))
+	if err != nil {
+		b.bot.Send(m.Sender, "Sử dụng cú pháp /attempts [user id] để xem các lượt trả lời của người dùng.")
+		return
+	}
+	attempts, err := b.storage.GetAttempts(userID)
+	if err != nil {
+		log.Printf("Cannot get attempts of %d: %s", userID, err.Error())
+		b.bot.Send(m.Sender, "Không thể lấy các lượt trả lời, thử lại sau.")
+		return
+	}
+	if len(attempts) == 0 {
+		b.bot.Send(m.Sender, "Người dùng này chưa trả lời câu hỏi nào.")
+		return
+	}
+	message := fmt.Sprintf("Người dùng %d đã làm %d lượt:\n", userID, len(attempts))
+	first := 0
+	if len(attempts) > attemptsShown {
+		first = len(attempts) - attemptsShown
+		message += fmt.Sprintf("(chỉ hiện %d lượt gần nhất)\n", attemptsShown)
+	}
+	for index := first; index < len(attempts); index++ {
+		message += fmt.Sprintf("\nLượt %d. %s", index+1, attempts[index].String())
+	}
+	b.bot.Send(m.Sender, message)
+}
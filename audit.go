@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+func (r AuditReport) String() string {
+	message := fmt.Sprintf("Đã kiểm tra %d top và %d lượt mời.\n", r.Tops, r.Invites)
+	if len(r.Discrepancies) == 0 && len(r.Orphans) == 0 {
+		return message + "Bảng xếp hạng khớp với danh sách mời."
+	}
+	if len(r.Discrepancies) > 0 {
+		message += fmt.Sprintf("\n%d top bị lệch:\n", len(r.Discrepancies))
+		for _, discrepancy := range r.Discrepancies {
+			message += fmt.Sprintf("- %s (%d): %d điểm, thực tế mời %d người\n",
+				strings.TrimSpace(discrepancy.Name), discrepancy.UserID, discrepancy.Point, discrepancy.Invites)
+		}
+	}
+	if len(r.Orphans) > 0 {
+		message += fmt.Sprintf("\n%d lượt mời không có top của người mời:\n", len(r.Orphans))
+		for _, orphan := range r.Orphans {
+			message += fmt.Sprintf("- %s (%d) mời %s (%d)\n",
+				strings.TrimSpace(orphan.Name), orphan.UserID, strings.TrimSpace(orphan.InvitedName), orphan.InvitedID)
+		}
+	}
+	if r.Repaired {
+		message += "\nĐã sửa lại bảng xếp hạng."
+	} else {
+		message += "\nDùng /audit repair để sửa lại bảng xếp hạng."
+	}
+	return message
+}
+
+func (b Bot) handleAudit(m *tb.Message) {
+	if !b.checkAdmin(m) {
+		return
+	}
+	repair := strings.TrimSpace(m.Payload) == "repair"
+	report, err := b.storage.Audit(repair)
+	if err != nil {
+		log.Printf("Cannot audit %s: %s", b.campaign.ID, err.Error())
+		b.bot.Send(m.Sender, "Không thể kiểm tra bảng xếp hạng, thử lại sau.")
+		return
+	}
+	b.bot.Send(m.Sender, report.String())
+}
+
+// runAudit audit [-campaign id] [-repair], audit the leaderboard of the campaigns.
+// The db is opened read only unless -repair, the bot must be stopped first
+func runAudit(args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	campaignID := flags.String("campaign", "", "only audit this campaign")
+	repair := flags.Bool("repair", false, "recompute the drifted tops")
+	flags.Parse(args)
+
+	botConfig, err := readConfigFromFile(configPath)
+	if err != nil {
+		return err
+	}
+	open := NewReadOnlyStorage
+	if *repair {
+		open = NewBoltStorage
+	}
+	storage, err := open()
+	if err != nil {
+		return err
+	}
+	for _, campaign := range botConfig.campaigns() {
+		if *campaignID != "" && campaign.ID != *campaignID {
+			continue
+		}
+		report, err := storage.Campaign(campaign.ID).Audit(*repair)
+		if err != nil {
+			return fmt.Errorf("campaign %s: %s", campaign.ID, err.Error())
+		}
+		fmt.Printf("== %s ==\n%s\n\n", campaign.ID, report)
+	}
+	return nil
+}
@@ -1,26 +1,85 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/asdine/storm"
+	"github.com/asdine/storm/codec"
 	"github.com/asdine/storm/q"
+	"github.com/coreos/bbolt"
 )
 
 // Question objects
 type Question struct {
-	ID              int64 `storm:"id"`
-	Rands           []int
+	ID int64 `storm:"id"`
+	// Rands indexes of the questions of sessions started before the
+	// questions had ids
+	Rands []int
+	// QuestionIDs questions of the attempt in the order they are asked
+	QuestionIDs []string
+	// Permutations order the options of each question are shown in, the
+	// i-th shown option is the Permutations[question][i]-th option of the
+	// file. The options are in the file order without a permutation
+	Permutations    [][]int
 	CurrentQuestion int
+	Session         string
+	MessageID       int
+	Deadline        int64 `storm:"index"`
+	QuizDeadline    int64
+	SentAt          int64
+	// Version question set version of the attempt
+	Version int
+	// Selected options chosen so far for a multi choice question, in the
+	// order they are shown
+	Selected []int
+}
+
+// originalOptions options of the file the options shown at the position are
+func (q Question) originalOptions(position int, shown []int) []int {
+	if position >= len(q.Permutations) {
+		return shown
+	}
+	permutation := q.Permutations[position]
+	options := []int{}
+	for _, option := range shown {
+		if option >= 0 && option < len(permutation) {
+			option = permutation[option]
+		}
+		options = append(options, option)
+	}
+	return options
+}
+
+// count questions of the attempt
+func (q Question) count() int {
+	if len(q.QuestionIDs) > 0 {
+		return len(q.QuestionIDs)
+	}
+	return len(q.Rands)
+}
+
+func (q Question) selected(option int) bool {
+	for _, selected := range q.Selected {
+		if selected == option {
+			return true
+		}
+	}
+	return false
 }
 
 // InviteUser user invited object
 type InviteUser struct {
-	ID              int    `storm:"id,increment"`
-	UserID          int    `storm:"index"`
-	InvitedID       int    `storm:"unique"`
+	ID        int `storm:"id,increment"`
+	UserID    int `storm:"index"`
+	InvitedID int `storm:"unique"`
+	// LuckyNumber picked before the ticket ledger, see MigrateTickets
 	LuckyNumber     string `storm:"index"`
 	InvitedUsername string
 	Username        string
@@ -29,6 +88,58 @@ type InviteUser struct {
 	Valid           bool
 }
 
+// TicketSource what earned a ticket
+type TicketSource string
+
+const (
+	// TicketQuiz ticket for passing the quiz, one per user
+	TicketQuiz TicketSource = "quiz"
+	// TicketInvite ticket for inviting a user into the group, one per invited user
+	TicketInvite TicketSource = "invite"
+	// TicketBonus ticket given by an admin
+	TicketBonus TicketSource = "bonus"
+)
+
+// Ticket right of a user to pick a lucky number, the ticket is open until a
+// number is picked. Valid is false while the owner is out of the group and a
+// revoked ticket never counts again
+type Ticket struct {
+	ID        int `storm:"id,increment"`
+	Owner     int `storm:"index"`
+	OwnerName string
+	Source    TicketSource
+	// Ref what earned the ticket: the owner for the quiz ticket and the
+	// invited user for an invite ticket
+	Ref           int
+	Number        string `storm:"index"`
+	Reason        string
+	Valid         bool
+	IssuedAt      int64
+	FilledAt      int64
+	RevokedAt     int64 `storm:"index"`
+	RevokedReason string
+	// Changes numbers changed or released after the ticket was filled
+	Changes []NumberChange
+}
+
+// NumberChange number of a ticket changed by its owner, To is empty when the
+// number was released
+type NumberChange struct {
+	From      string
+	To        string
+	ChangedAt int64
+}
+
+// active check if the ticket is not revoked
+func (t Ticket) active() bool {
+	return t.RevokedAt == 0
+}
+
+// open check if the ticket can still pick a number
+func (t Ticket) open() bool {
+	return t.active() && t.Number == ""
+}
+
 // Top user who invite most friend
 type Top struct {
 	ID    int `storm:"id"`
@@ -37,17 +148,90 @@ type Top struct {
 	Valid bool
 }
 
-// Score of a user
+// QuestionResult how a user did on a question of the quiz
+type QuestionResult struct {
+	// Question id of the question
+	Question   string
+	Difficulty Difficulty
+	Points     float64
+	MaxPoints  float64
+	Correct    bool
+}
+
+// Attempt one run of the quiz started with /start, attempts are kept when the
+// user starts again
+type Attempt struct {
+	ID      int    `storm:"id,increment"`
+	UserID  int    `storm:"index"`
+	Session string `storm:"unique"`
+	// Version question set version the attempt started with
+	Version int
+	// Questions ids of the questions of the attempt
+	Questions  []string
+	Answers    []AttemptAnswer
+	StartedAt  int64
+	FinishedAt int64
+}
+
+// AttemptAnswer what the user answered to a question of an attempt
+type AttemptAnswer struct {
+	QuestionResult
+	// Index position of the question in the attempt
+	Index int
+	// Options chosen options, in the file order
+	Options []int
+	// Permutation order the options were shown in
+	Permutation []int
+	Text        string
+	TimedOut    bool
+	SentAt      int64
+	AnsweredAt  int64
+}
+
+// Score of a user, Score counts the correct answers and Points adds up the
+// points of every answer
 type Score struct {
-	ID          int `storm:"id"`
-	Score       int
-	UserName    string
-	FirstName   string
-	LastName    string
+	ID        int `storm:"id"`
+	Score     int
+	Points    float64
+	Results   []QuestionResult
+	UserName  string
+	FirstName string
+	LastName  string
+	// LuckyNumber picked before the ticket ledger, see MigrateTickets
 	LuckyNumber string `storm:"index"`
 	Valid       bool
 }
 
+// Winner prize awarded in the draw, Number is empty for the top inviters pool
+type Winner struct {
+	Rank   int
+	Tier   string
+	Pool   string
+	Number string
+	Users  []User
+}
+
+// Draw commit and result of the lucky number draw
+type Draw struct {
+	ID          int `storm:"id"`
+	SeedHash    string
+	Seed        string
+	CommittedAt int64
+	DrawnAt     int64
+	Winners     []Winner
+}
+
+// Conversation persisted dialog state of a user in a chat
+type Conversation struct {
+	ID     string `storm:"id"`
+	State  ConversationState
+	Number string
+	// Ticket ticket waiting for its new number
+	Ticket    int
+	ExpiresAt int64
+}
+
 // User for checking who
 type User struct {
 	ID          int
@@ -64,24 +248,87 @@ func NewUser(id int, name, lucky string) User {
 	}
 }
 
-// QuestionStorage bot database
+// Participant campaign a user takes part in private chat
+type Participant struct {
+	ID         int `storm:"id"`
+	CampaignID string
+}
+
+// QuestionStorage bot database, the root storage keeps campaigns and
+// participants while each campaign stores its records in its own node
 type QuestionStorage struct {
-	db *storm.DB
+	db storm.Node
+	// boltDB for the lookups storm has no query for
+	boltDB *bolt.DB
 }
 
+// dbPath path of the db of the bot
+const dbPath = "/db/questions.db"
+
 // NewBoltStorage init storage
 func NewBoltStorage() (*QuestionStorage, error) {
-	db, err := storm.Open("/db/questions.db")
+	// don't wait forever when another process (the bot or a cli command) holds the db
+	return openBoltStorage(dbPath, &bolt.Options{Timeout: 5 * time.Second})
+}
+
+// NewReadOnlyStorage open the db for a cli command which only reads it, the
+// bot locks the db while it runs so it must be stopped first
+func NewReadOnlyStorage() (*QuestionStorage, error) {
+	return openBoltStorage(dbPath, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+}
 
+func openBoltStorage(path string, options *bolt.Options) (*QuestionStorage, error) {
+	db, err := storm.Open(path, storm.BoltOptions(0600, options))
+	if err == bolt.ErrTimeout {
+		err = fmt.Errorf("%s, is the bot still running? stop it first", err.Error())
+	}
 	if err != nil {
 		log.Printf("Cannot open db: %s", err.Error())
+		return nil, err
 	}
 	storage := &QuestionStorage{
-		db: db,
+		db:     db,
+		boltDB: db.Bolt,
 	}
 	return storage, nil
 }
 
+// Campaign storage scoped to a campaign
+func (storage *QuestionStorage) Campaign(id string) *QuestionStorage {
+	return &QuestionStorage{
+		db:     storage.db.From("campaigns", id),
+		boltDB: storage.boltDB,
+	}
+}
+
+// SaveCampaign save or update a campaign so it stays queryable after it ends
+func (storage *QuestionStorage) SaveCampaign(campaign Campaign) error {
+	err := storage.db.Save(&campaign)
+	if err != nil {
+		log.Printf("Cannot save campaign %s: %s", campaign.ID, err.Error())
+	}
+	return err
+}
+
+// GetCampaigns get all campaigns ever run
+func (storage *QuestionStorage) GetCampaigns() ([]Campaign, error) {
+	var campaigns []Campaign
+	err := storage.db.All(&campaigns)
+	return campaigns, err
+}
+
+// GetParticipant get the campaign a user takes part in
+func (storage *QuestionStorage) GetParticipant(userID int) (Participant, error) {
+	var participant Participant
+	err := storage.db.One("ID", userID, &participant)
+	return participant, err
+}
+
+// UpdateParticipant switch the campaign of a user
+func (storage *QuestionStorage) UpdateParticipant(participant Participant) error {
+	return storage.db.Save(&participant)
+}
+
 // GetUserScore get user score
 func (storage *QuestionStorage) GetUserScore(userID int) (Score, error) {
 	var result Score
@@ -135,7 +382,7 @@ func (storage *QuestionStorage) RemoveScore(userID int) error {
 	return err
 }
 
-//Abs return absolute value of a number
+// Abs return absolute value of a number
 func Abs(x int) int {
 	if x < 0 {
 		return -x
@@ -143,80 +390,126 @@ func Abs(x int) int {
 	return x
 }
 
-// Who Get list people who choose a lucky number (string)
+// ticketNumberIndex bucket of the storm index of Ticket.Number, its keys are
+// the number, "__" and the ticket id, sorted by number since the numbers of a
+// campaign have the same digits
+const ticketNumberIndex = "__storm_index_Number"
+
+// ticketRevokedIndex bucket of the storm index of Ticket.RevokedAt, storm
+// doesn't index zero values so only the revoked tickets are in it
+const ticketRevokedIndex = "__storm_index_RevokedAt"
+
+// indexKeySuffix "__" and the 8 bytes of the ticket id ending a key of the
+// number index
+const indexKeySuffix = 2 + 8
+
+// indexNumber number of a key of the number index
+func indexNumber(key []byte) string {
+	return string(key[:len(key)-indexKeySuffix])
+}
+
+// Who Get list people who choose a lucky number (string), or who choose the
+// nearest number if nobody did. On a tie people of both numbers are returned,
+// the lower number first, then by ticket. Only active tickets of users in the
+// group count
 func (storage *QuestionStorage) Who(lucky string) ([]User, error) {
 	result := []User{}
-	var scores []Score
-	var inviteUsers []InviteUser
-	err := storage.db.Find("LuckyNumber", lucky, &scores)
+	target, err := strconv.Atoi(lucky)
 	if err != nil {
-		log.Printf("Cannot find lucky number: %s", err.Error())
 		return result, err
 	}
-
-	err = storage.db.Find("LuckyNumber", lucky, &inviteUsers)
+	var below, above []Ticket
+	err = storage.boltDB.View(func(tx *bolt.Tx) error {
+		bucket := storage.db.GetBucket(tx, "Ticket")
+		if bucket == nil {
+			return nil
+		}
+		index := bucket.Bucket([]byte(ticketNumberIndex))
+		if index == nil {
+			return nil
+		}
+		cursor := index.Cursor()
+		// seek the target, then walk up to the first number with a counted ticket
+		key, id := cursor.Seek([]byte(lucky))
+		above, err = nearestTickets(storage.db.Codec(), bucket, key, id, cursor.Next)
+		if err != nil || (len(above) > 0 && above[0].Number == lucky) {
+			return err
+		}
+		// and down from the target
+		key, id = cursor.Seek([]byte(lucky))
+		if key == nil {
+			key, id = cursor.Last()
+		} else {
+			key, id = cursor.Prev()
+		}
+		below, err = nearestTickets(storage.db.Codec(), bucket, key, id, cursor.Prev)
+		return err
+	})
 	if err != nil {
-		log.Printf("Cannot find lucky number from top: %s", err.Error())
+		log.Printf("Cannot find lucky number: %s", err.Error())
 		return result, err
 	}
-	for _, score := range scores {
-		if score.Valid == false {
-			continue
+	tickets := above
+	if len(above) == 0 || above[0].Number != lucky {
+		tickets = nearest(target, below, above)
+	}
+	sort.SliceStable(tickets, func(i, j int) bool {
+		if tickets[i].Number != tickets[j].Number {
+			return tickets[i].Number < tickets[j].Number
 		}
-		user := NewUser(score.ID, fmt.Sprintf("%s %s", score.FirstName, score.LastName), score.LuckyNumber)
-		result = append(result, user)
+		return tickets[i].ID < tickets[j].ID
+	})
+	for _, ticket := range tickets {
+		result = append(result, NewUser(ticket.Owner, ticket.OwnerName, ticket.Number))
 	}
-	for _, invite := range inviteUsers {
-		if invite.Valid == false {
+	return result, nil
+}
+
+// nearestTickets walk the number index from the key with next and return the
+// counted tickets of the first number which has any
+func nearestTickets(codec codec.MarshalUnmarshaler, bucket *bolt.Bucket, key, id []byte, next func() ([]byte, []byte)) ([]Ticket, error) {
+	tickets := []Ticket{}
+	number := ""
+	for ; key != nil; key, id = next() {
+		// the ids of the index are in a nested bucket
+		if id == nil {
 			continue
 		}
-		user := NewUser(invite.UserID, invite.Name, invite.LuckyNumber)
-		result = append(result, user)
-	}
-	if len(result) != 0 {
-		return result, err
-	}
-	storage.db.AllByIndex("LuckyNumber", &scores)
-	storage.db.AllByIndex("LuckyNumber", &inviteUsers)
-	max := NewUser(0, "", "0000")
-	min := NewUser(0, "", "9999")
-	for _, score := range scores {
-		if score.Valid {
-			if score.LuckyNumber > lucky && min.LuckyNumber > score.LuckyNumber {
-				min = NewUser(score.ID, fmt.Sprintf("%s %s", score.FirstName, score.LastName), score.LuckyNumber)
-			}
-			if score.LuckyNumber < lucky && max.LuckyNumber < score.LuckyNumber {
-				max = NewUser(score.ID, fmt.Sprintf("%s %s", score.FirstName, score.LastName), score.LuckyNumber)
-			}
+		// the id may contain "__" too, split at its fixed size
+		if len(key) <= indexKeySuffix {
+			continue
 		}
-	}
-	for _, score := range inviteUsers {
-		if score.Valid {
-			if score.LuckyNumber > lucky && min.LuckyNumber > score.LuckyNumber {
-				min = NewUser(score.UserID, score.Name, score.LuckyNumber)
-			}
-			if score.LuckyNumber < lucky && max.LuckyNumber < score.LuckyNumber {
-				max = NewUser(score.UserID, score.Name, score.LuckyNumber)
-			}
+		keyNumber := indexNumber(key)
+		if len(tickets) > 0 && keyNumber != number {
+			break
 		}
-	}
-	if min.ID != 0 {
-		if max.ID == 0 {
-			result = append(result, min)
-		} else {
-			intMin, _ := strconv.Atoi(min.LuckyNumber)
-			intMax, _ := strconv.Atoi(max.LuckyNumber)
-			intLucky, _ := strconv.Atoi(lucky)
-			if Abs(intMin-intLucky) < Abs(intMax-intLucky) {
-				result = append(result, min)
-			} else {
-				result = append(result, max)
-			}
+		var ticket Ticket
+		if err := codec.Unmarshal(bucket.Get(id), &ticket); err != nil {
+			return tickets, err
+		}
+		if !ticket.active() || !ticket.Valid {
+			continue
 		}
-	} else if max.ID != 0 {
-		result = append(result, max)
+		number = keyNumber
+		tickets = append(tickets, ticket)
 	}
-	return result, err
+	return tickets, nil
+}
+
+// nearest the tickets of the number nearest to the target, both on a tie
+func nearest(target int, below, above []Ticket) []Ticket {
+	if len(below) == 0 || len(above) == 0 {
+		return append(below, above...)
+	}
+	low, _ := strconv.Atoi(below[0].Number)
+	high, _ := strconv.Atoi(above[0].Number)
+	switch {
+	case target-low < high-target:
+		return below
+	case target-low > high-target:
+		return above
+	}
+	return append(below, above...)
 }
 
 // GetCurrentQuestion get current question for user
@@ -243,160 +536,1244 @@ func (storage *QuestionStorage) UpdateQuestion(id int64, question Question) erro
 	return err
 }
 
-// RemoveQuestion remove question list to restart
-func (storage *QuestionStorage) RemoveQuestion(id int64) error {
-	current, err := storage.GetCurrentQuestion(id)
-	if err != nil {
-		log.Printf("Cannot get question.")
+// ErrAlreadyInvited the invited user is already in the invite records
+var ErrAlreadyInvited = errors.New("user is already invited")
+
+// countTop set the top point of an inviter to the number of valid users he
+// invited, so the leaderboard is always derived from the invite records
+func countTop(tx storm.Node, userID int, name string) error {
+	var invites []InviteUser
+	err := tx.Find("UserID", userID, &invites)
+	if err != nil && err != storm.ErrNotFound {
 		return err
 	}
-	log.Printf("Remove question: %+v", current)
-	err = storage.db.DeleteStruct(&current)
-	if err != nil {
-		log.Printf("Cannot remove question: %s", err.Error())
+	valid := 0
+	for _, invite := range invites {
+		if invite.Valid {
+			valid++
+		}
+	}
+	var top Top
+	err = tx.One("ID", userID, &top)
+	if err == storm.ErrNotFound {
+		top = Top{
+			ID:    userID,
+			Name:  name,
+			Valid: true,
+		}
+	} else if err != nil {
+		return err
+	}
+	top.Point = valid
+	err = tx.Save(&top)
+	if err == nil {
+		log.Printf("Saved top: %+v", top)
 	}
 	return err
 }
 
-// GetInvitedUserWithoutLuckyNumber get user list so the bot can update lucky number for that user
-func (storage *QuestionStorage) GetInvitedUserWithoutLuckyNumber(userID int) ([]InviteUser, error) {
-	var invitedUsers []InviteUser
-	query := storage.db.Select(q.And(q.Eq("UserID", userID), q.Eq("LuckyNumber", "")))
-	err := query.Find(&invitedUsers)
+// RecordInvite save a new invite and count it to the inviter top point
+func (storage *QuestionStorage) RecordInvite(invite InviteUser) error {
+	tx, err := storage.db.Begin(true)
 	if err != nil {
-		log.Printf("Cannot get user: %s", err.Error())
+		log.Printf("Cannot begin transaction: %s", err.Error())
+		return err
 	}
-	return invitedUsers, err
-}
+	defer tx.Rollback()
 
-// InvitedUser get invited user
-func (storage *QuestionStorage) InvitedUser(userID int, invitedUser InviteUser) error {
-	err := storage.db.Save(&invitedUser)
+	var existing InviteUser
+	err = tx.One("InvitedID", invite.InvitedID, &existing)
+	if err == nil {
+		return ErrAlreadyInvited
+	}
+	if err != storm.ErrNotFound {
+		return err
+	}
+	err = tx.Save(&invite)
 	if err != nil {
 		log.Printf("Cannot add invited user: %s", err.Error())
+		return err
 	}
-	return err
+	_, err = issueTicket(tx, inviteTicket(invite))
+	if err != nil && err != ErrTicketIssued {
+		return err
+	}
+	err = countTop(tx, invite.UserID, invite.Name)
+	if err != nil {
+		log.Printf("Cannot update top point: %s", err.Error())
+		return err
+	}
+	return tx.Commit()
 }
 
-// UpdateInviteUser update lucky number to invite user
-func (storage *QuestionStorage) UpdateInviteUser(invitedUser InviteUser) error {
-	err := storage.db.Update(&invitedUser)
+// RevokeInvite remove the invite of a user and discount it from the inviter top point
+func (storage *QuestionStorage) RevokeInvite(invitedID int) (InviteUser, error) {
+	var invite InviteUser
+	tx, err := storage.db.Begin(true)
 	if err != nil {
-		log.Printf("Cannot update invited user: %s", err.Error())
+		log.Printf("Cannot begin transaction: %s", err.Error())
+		return invite, err
 	}
-	if invitedUser.Valid == false {
-		err = storage.db.UpdateField(&invitedUser, "Valid", false)
-		if err != nil {
-			log.Printf("Cannot update invited valid: %s", err.Error())
-		}
+	defer tx.Rollback()
+
+	err = tx.One("InvitedID", invitedID, &invite)
+	if err != nil {
+		return invite, err
 	}
-	return err
+	err = tx.DeleteStruct(&invite)
+	if err != nil {
+		log.Printf("Cannot remove invited user: %s", err.Error())
+		return invite, err
+	}
+	err = revokeTickets(tx, TicketInvite, invitedID, "người được mời đã rời khỏi group")
+	if err != nil {
+		return invite, err
+	}
+	err = countTop(tx, invite.UserID, invite.Name)
+	if err != nil {
+		log.Printf("Cannot update top point: %s", err.Error())
+		return invite, err
+	}
+	return invite, tx.Commit()
 }
 
-// RemoveUser remove a user
-func (storage *QuestionStorage) RemoveUser(userLeftID int) error {
-	var userLeft InviteUser
-	err := storage.db.One("InvitedID", userLeftID, &userLeft)
+// UpdateInvitesValid count or stop counting the invites of the inviter, when
+// the inviter comes back to or leaves the group, and recount the top
+func (storage *QuestionStorage) UpdateInvitesValid(userID int, valid bool) error {
+	tx, err := storage.db.Begin(true)
 	if err != nil {
-		log.Printf("Cannot get user left: %s", err.Error())
+		log.Printf("Cannot begin transaction: %s", err.Error())
 		return err
 	}
-	storage.db.DeleteStruct(&userLeft)
-	return err
-}
+	defer tx.Rollback()
 
-// GetInvitedUser Get a user
-func (storage *QuestionStorage) GetInvitedUser(userID int) ([]InviteUser, error) {
-	var users []InviteUser
-	err := storage.db.Find("UserID", userID, &users)
+	var invites []InviteUser
+	err = tx.Find("UserID", userID, &invites)
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	var top Top
+	err = tx.One("ID", userID, &top)
+	if err == storm.ErrNotFound && len(invites) == 0 {
+		return nil
+	}
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+	for _, invite := range invites {
+		if invite.Valid == valid {
+			continue
+		}
+		invite.Valid = valid
+		err = tx.Save(&invite)
+		if err != nil {
+			log.Printf("Cannot update invited valid: %s", err.Error())
+			return err
+		}
+	}
+	name := top.Name
+	if len(invites) > 0 {
+		name = invites[0].Name
+	}
+	err = countTop(tx, userID, name)
 	if err != nil {
-		log.Printf("Cannot get invited user: %s", err.Error())
+		log.Printf("Cannot update top point: %s", err.Error())
+		return err
 	}
-	return users, err
-}
-
-// GetInvitedUserByInvitedID get invited user by invited id
-func (storage *QuestionStorage) GetInvitedUserByInvitedID(invitedID int) (InviteUser, error) {
-	var user InviteUser
-	err := storage.db.One("InvitedID", invitedID, &user)
+	err = tx.One("ID", userID, &top)
 	if err != nil {
-		log.Printf("Cannot get invited user")
+		return err
 	}
-	return user, err
+	top.Valid = valid
+	err = tx.Save(&top)
+	if err != nil {
+		log.Printf("Cannot update top valid: %s", err.Error())
+		return err
+	}
+	return tx.Commit()
 }
 
-// UpdateTop update top point
-func (storage *QuestionStorage) UpdateTop(userID int, username string, point int) error {
-	var top Top
-	err := storage.db.One("ID", userID, &top)
+// TransferInvite move the invite of a user to a new inviter, it returns the
+// previous invite, or ErrAlreadyInvited if the user is invited by the same inviter
+func (storage *QuestionStorage) TransferInvite(invite InviteUser) (InviteUser, error) {
+	var previous InviteUser
+	tx, err := storage.db.Begin(true)
 	if err != nil {
-		top.ID = userID
-		top.Point += point
-		top.Name = username
-		top.Valid = true
-		err = storage.db.Save(&top)
+		log.Printf("Cannot begin transaction: %s", err.Error())
+		return previous, err
+	}
+	defer tx.Rollback()
+
+	err = tx.One("InvitedID", invite.InvitedID, &previous)
+	if err != nil && err != storm.ErrNotFound {
+		return previous, err
+	}
+	if err == nil {
+		if previous.UserID == invite.UserID {
+			return previous, ErrAlreadyInvited
+		}
+		err = tx.DeleteStruct(&previous)
 		if err != nil {
-			log.Printf("Cannot save top point: %s", err.Error())
-		} else {
-			log.Printf("Saved top: %+v", top)
+			log.Printf("Cannot remove invited user: %s", err.Error())
+			return previous, err
 		}
-	} else {
-		point := top.Point + point
-		err = storage.db.UpdateField(&top, "Point", point)
+		err = revokeTickets(tx, TicketInvite, previous.InvitedID, "người được mời đã được mời lại bởi người khác")
+		if err != nil {
+			return previous, err
+		}
+		err = countTop(tx, previous.UserID, previous.Name)
 		if err != nil {
 			log.Printf("Cannot update top point: %s", err.Error())
-		} else {
-			log.Printf("Updated top: %+v", top)
+			return previous, err
 		}
 	}
-	return err
+	err = tx.Save(&invite)
+	if err != nil {
+		log.Printf("Cannot add invited user: %s", err.Error())
+		return previous, err
+	}
+	_, err = issueTicket(tx, inviteTicket(invite))
+	if err != nil && err != ErrTicketIssued {
+		return previous, err
+	}
+	err = countTop(tx, invite.UserID, invite.Name)
+	if err != nil {
+		log.Printf("Cannot update top point: %s", err.Error())
+		return previous, err
+	}
+	return previous, tx.Commit()
 }
 
-// GetTop get top point
-func (storage *QuestionStorage) GetTop() ([]Top, error) {
-	var tops []Top
-	err := storage.db.AllByIndex("Point", &tops)
-	return tops, err
+// ErrTicketIssued the quiz or invite ticket was issued already
+var ErrTicketIssued = errors.New("ticket is issued already")
+
+// ErrNoTicket the user has no open ticket
+var ErrNoTicket = errors.New("no open ticket")
+
+// ErrNumberTaken the number is picked by as many tickets as the campaign allows
+var ErrNumberTaken = errors.New("number is taken")
+
+// numberTaken check if limit active tickets other than the ticket picked the
+// number already, limit 0 means no limit
+func numberTaken(tx storm.Node, number string, limit int, ticketID int) (bool, error) {
+	if limit <= 0 || number == "" {
+		return false, nil
+	}
+	var tickets []Ticket
+	err := tx.Find("Number", number, &tickets)
+	if err == storm.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	count := 0
+	for _, ticket := range tickets {
+		if ticket.active() && ticket.ID != ticketID {
+			count++
+		}
+	}
+	return count >= limit, nil
 }
 
-// GetTopByUserID get top by user id
-func (storage *QuestionStorage) GetTopByUserID(userID int) (Top, error) {
-	var top Top
-	err := storage.db.One("ID", userID, &top)
-	return top, err
+// issueTicket save a new ticket, quiz and invite tickets are issued once for
+// their ref and ErrTicketIssued returns the active one
+func issueTicket(tx storm.Node, ticket Ticket) (Ticket, error) {
+	if ticket.Source != TicketBonus {
+		var tickets []Ticket
+		err := tx.Select(q.Eq("Source", ticket.Source), q.Eq("Ref", ticket.Ref), q.Eq("RevokedAt", int64(0))).Find(&tickets)
+		if err != nil && err != storm.ErrNotFound {
+			return ticket, err
+		}
+		if len(tickets) > 0 {
+			return tickets[0], ErrTicketIssued
+		}
+	}
+	ticket.Valid = true
+	ticket.IssuedAt = time.Now().Unix()
+	err := tx.Save(&ticket)
+	if err != nil {
+		log.Printf("Cannot save ticket: %s", err.Error())
+	}
+	return ticket, err
 }
 
-// UpdateTopObject update top object
-func (storage *QuestionStorage) UpdateTopObject(top Top) error {
-	err := storage.db.Update(&top)
+// revokeTickets revoke the active tickets of the source and ref
+func revokeTickets(tx storm.Node, source TicketSource, ref int, reason string) error {
+	var tickets []Ticket
+	err := tx.Select(q.Eq("Source", source), q.Eq("Ref", ref), q.Eq("RevokedAt", int64(0))).Find(&tickets)
+	if err == storm.ErrNotFound {
+		return nil
+	}
 	if err != nil {
-		log.Printf("Cannot update top object: %s", err.Error())
+		return err
 	}
-	if top.Valid == false {
-		err = storage.db.UpdateField(&top, "Valid", false)
+	for _, ticket := range tickets {
+		ticket.RevokedAt = time.Now().Unix()
+		ticket.RevokedReason = reason
+		err = tx.Save(&ticket)
 		if err != nil {
-			log.Printf("Cannot update top valid:  %s", err.Error())
+			log.Printf("Cannot revoke ticket: %s", err.Error())
+			return err
 		}
 	}
-	return err
+	return nil
 }
 
-// GetAllInvitedUser Get all invited users
-func (storage *QuestionStorage) GetAllInvitedUser() ([]InviteUser, error) {
-	var users []InviteUser
-	err := storage.db.All(&users)
+// inviteTicket ticket earned by an invite
+func inviteTicket(invite InviteUser) Ticket {
+	return Ticket{
+		Owner:     invite.UserID,
+		OwnerName: invite.Name,
+		Source:    TicketInvite,
+		Ref:       invite.InvitedID,
+		Reason:    strings.TrimSpace(invite.InvitedName),
+	}
+}
+
+// IssueTicket save a new ticket, quiz and invite tickets are issued once for
+// their ref and ErrTicketIssued returns the active one
+func (storage *QuestionStorage) IssueTicket(ticket Ticket) (Ticket, error) {
+	tx, err := storage.db.Begin(true)
 	if err != nil {
-		log.Printf("Cannot get all invited user: %s", err.Error())
+		log.Printf("Cannot begin transaction: %s", err.Error())
+		return ticket, err
 	}
-	return users, err
+	defer tx.Rollback()
+
+	ticket, err = issueTicket(tx, ticket)
+	if err != nil {
+		return ticket, err
+	}
+	return ticket, tx.Commit()
 }
 
-// GetAllUserScore Get all user score
-func (storage *QuestionStorage) GetAllUserScore() ([]Score, error) {
-	var scores []Score
-	err := storage.db.All(&scores)
+// FillTicket pick the number with the oldest open ticket of the owner, it
+// returns the ticket and how many open tickets are left. ErrNumberTaken when
+// limit tickets picked the number already, limit 0 means no limit
+func (storage *QuestionStorage) FillTicket(owner int, number string, limit int) (Ticket, int, error) {
+	var ticket Ticket
+	tx, err := storage.db.Begin(true)
 	if err != nil {
-		log.Printf("Cannot get all scores: %s", err.Error())
+		log.Printf("Cannot begin transaction: %s", err.Error())
+		return ticket, 0, err
 	}
-	return scores, err
+	defer tx.Rollback()
+
+	var tickets []Ticket
+	err = tx.Find("Owner", owner, &tickets)
+	if err != nil && err != storm.ErrNotFound {
+		return ticket, 0, err
+	}
+	open := []Ticket{}
+	for _, ticket := range tickets {
+		if ticket.open() {
+			open = append(open, ticket)
+		}
+	}
+	if len(open) == 0 {
+		return ticket, 0, ErrNoTicket
+	}
+	taken, err := numberTaken(tx, number, limit, 0)
+	if err != nil {
+		return ticket, 0, err
+	}
+	if taken {
+		return ticket, len(open), ErrNumberTaken
+	}
+	ticket = open[0]
+	ticket.Number = number
+	ticket.FilledAt = time.Now().Unix()
+	err = tx.Save(&ticket)
+	if err != nil {
+		log.Printf("Cannot fill ticket: %s", err.Error())
+		return ticket, 0, err
+	}
+	return ticket, len(open) - 1, tx.Commit()
+}
+
+// ChangeTicketNumber change the number of a filled ticket of the owner, an
+// empty number releases the ticket so it can be filled again. The limit is
+// the same as FillTicket
+func (storage *QuestionStorage) ChangeTicketNumber(owner, id int, number string, limit int) (Ticket, error) {
+	var ticket Ticket
+	tx, err := storage.db.Begin(true)
+	if err != nil {
+		log.Printf("Cannot begin transaction: %s", err.Error())
+		return ticket, err
+	}
+	defer tx.Rollback()
+
+	err = tx.One("ID", id, &ticket)
+	if err == storm.ErrNotFound {
+		return ticket, ErrNoTicket
+	}
+	if err != nil {
+		return ticket, err
+	}
+	if ticket.Owner != owner || !ticket.active() || ticket.Number == "" {
+		return ticket, ErrNoTicket
+	}
+	taken, err := numberTaken(tx, number, limit, ticket.ID)
+	if err != nil {
+		return ticket, err
+	}
+	if taken {
+		return ticket, ErrNumberTaken
+	}
+	now := time.Now().Unix()
+	ticket.Changes = append(ticket.Changes, NumberChange{
+		From:      ticket.Number,
+		To:        number,
+		ChangedAt: now,
+	})
+	ticket.Number = number
+	ticket.FilledAt = now
+	if number == "" {
+		ticket.FilledAt = 0
+	}
+	err = tx.Save(&ticket)
+	if err != nil {
+		log.Printf("Cannot change ticket number: %s", err.Error())
+		return ticket, err
+	}
+	return ticket, tx.Commit()
+}
+
+// RevokeTicket revoke a ticket by id
+func (storage *QuestionStorage) RevokeTicket(id int, reason string) (Ticket, error) {
+	var ticket Ticket
+	err := storage.db.One("ID", id, &ticket)
+	if err != nil {
+		return ticket, err
+	}
+	if !ticket.active() {
+		return ticket, nil
+	}
+	ticket.RevokedAt = time.Now().Unix()
+	ticket.RevokedReason = reason
+	err = storage.db.Save(&ticket)
+	if err != nil {
+		log.Printf("Cannot revoke ticket: %s", err.Error())
+	}
+	return ticket, err
+}
+
+// GetTickets get every ticket of the owner, revoked ones included, from the oldest
+func (storage *QuestionStorage) GetTickets(owner int) ([]Ticket, error) {
+	var tickets []Ticket
+	err := storage.db.Find("Owner", owner, &tickets)
+	if err == storm.ErrNotFound {
+		return tickets, nil
+	}
+	return tickets, err
+}
+
+// NumberCounts active tickets of each picked number, counted on the keys of
+// the number index so only the revoked tickets are decoded
+func (storage *QuestionStorage) NumberCounts() (map[string]int, error) {
+	counts := map[string]int{}
+	err := storage.boltDB.View(func(tx *bolt.Tx) error {
+		bucket := storage.db.GetBucket(tx, "Ticket")
+		if bucket == nil {
+			return nil
+		}
+		if index := bucket.Bucket([]byte(ticketNumberIndex)); index != nil {
+			err := index.ForEach(func(key, id []byte) error {
+				// the ids of the index are in a nested bucket
+				if id != nil && len(key) > indexKeySuffix {
+					counts[indexNumber(key)]++
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		revoked := bucket.Bucket([]byte(ticketRevokedIndex))
+		if revoked == nil {
+			return nil
+		}
+		return revoked.ForEach(func(key, id []byte) error {
+			if id == nil {
+				return nil
+			}
+			var ticket Ticket
+			if err := storage.db.Codec().Unmarshal(bucket.Get(id), &ticket); err != nil {
+				return err
+			}
+			if counts[ticket.Number] > 1 {
+				counts[ticket.Number]--
+			} else {
+				delete(counts, ticket.Number)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Cannot count numbers: %s", err.Error())
+	}
+	return counts, err
+}
+
+// GetAllTickets get the tickets of every user
+func (storage *QuestionStorage) GetAllTickets() ([]Ticket, error) {
+	var tickets []Ticket
+	err := storage.db.All(&tickets)
+	return tickets, err
+}
+
+// UpdateTicketsValid count or stop counting the tickets of the owner, when
+// the owner comes back to or leaves the group
+func (storage *QuestionStorage) UpdateTicketsValid(owner int, valid bool) error {
+	tickets, err := storage.GetTickets(owner)
+	if err != nil {
+		return err
+	}
+	for _, ticket := range tickets {
+		if ticket.Valid == valid {
+			continue
+		}
+		ticket.Valid = valid
+		err = storage.db.Save(&ticket)
+		if err != nil {
+			log.Printf("Cannot update ticket valid: %s", err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+// legacyBuckets buckets of the records saved before the storage was scoped per campaign
+var legacyBuckets = []string{"Question", "Score", "InviteUser", "Top"}
+
+// MigrateLegacy move the records saved before the storage was scoped per
+// campaign into the campaign, the root buckets are removed once they are moved.
+// A bucket the campaign already has records in is left for the admin to check
+func (storage *QuestionStorage) MigrateLegacy(campaignID string) (int, error) {
+	count := 0
+	err := storage.boltDB.Update(func(tx *bolt.Tx) error {
+		for _, name := range legacyBuckets {
+			legacy := tx.Bucket([]byte(name))
+			if legacy == nil {
+				continue
+			}
+			campaigns, err := tx.CreateBucketIfNotExists([]byte("campaigns"))
+			if err != nil {
+				return err
+			}
+			campaign, err := campaigns.CreateBucketIfNotExists([]byte(campaignID))
+			if err != nil {
+				return err
+			}
+			if existing := campaign.Bucket([]byte(name)); existing != nil && existing.Stats().KeyN > 0 {
+				log.Printf("Cannot migrate %s into campaign %s: the campaign has %s records already", name, campaignID, name)
+				continue
+			}
+			bucket, err := campaign.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+			moved, err := copyBucket(legacy, bucket)
+			if err != nil {
+				return err
+			}
+			if err := tx.DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+			count += moved
+		}
+		return nil
+	})
+	return count, err
+}
+
+// copyBucket copy the records and the nested buckets, storm keeps its indexes
+// in nested buckets. It returns how many records of the bucket were copied
+func copyBucket(from, to *bolt.Bucket) (int, error) {
+	count := 0
+	err := from.ForEach(func(key, value []byte) error {
+		if value != nil {
+			count++
+			return to.Put(key, value)
+		}
+		nested, err := to.CreateBucketIfNotExists(key)
+		if err != nil {
+			return err
+		}
+		_, err = copyBucket(from.Bucket(key), nested)
+		return err
+	})
+	return count, err
+}
+
+// MigrateTickets issue the tickets of the lucky numbers picked before the
+// ticket ledger, once. Scores with passMark correct answers earn the quiz ticket
+func (storage *QuestionStorage) MigrateTickets(passMark int) (int, error) {
+	var migrated bool
+	err := storage.db.Get("tickets", "migrated", &migrated)
+	if err != nil && err != storm.ErrNotFound {
+		return 0, err
+	}
+	if migrated {
+		return 0, nil
+	}
+	tx, err := storage.db.Begin(true)
+	if err != nil {
+		log.Printf("Cannot begin transaction: %s", err.Error())
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	count := 0
+	var scores []Score
+	err = tx.All(&scores)
+	if err != nil {
+		return 0, err
+	}
+	for _, score := range scores {
+		if score.Score < passMark && score.LuckyNumber == "" {
+			continue
+		}
+		ticket, err := issueTicket(tx, Ticket{
+			Owner:     score.ID,
+			OwnerName: fmt.Sprintf("%s %s", score.FirstName, score.LastName),
+			Source:    TicketQuiz,
+			Ref:       score.ID,
+			Number:    score.LuckyNumber,
+		})
+		if err == ErrTicketIssued {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if !score.Valid {
+			ticket.Valid = false
+			if err := tx.Save(&ticket); err != nil {
+				return 0, err
+			}
+		}
+		count++
+	}
+	var invites []InviteUser
+	err = tx.All(&invites)
+	if err != nil {
+		return 0, err
+	}
+	for _, invite := range invites {
+		ticket := inviteTicket(invite)
+		ticket.Number = invite.LuckyNumber
+		ticket, err := issueTicket(tx, ticket)
+		if err == ErrTicketIssued {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if !invite.Valid {
+			ticket.Valid = false
+			if err := tx.Save(&ticket); err != nil {
+				return 0, err
+			}
+		}
+		count++
+	}
+	err = tx.Set("tickets", "migrated", true)
+	if err != nil {
+		return 0, err
+	}
+	return count, tx.Commit()
+}
+
+// IndexRevokedTickets add the tickets revoked before Ticket.RevokedAt was
+// indexed to the index, once, NumberCounts doesn't see them otherwise
+func (storage *QuestionStorage) IndexRevokedTickets() (int, error) {
+	var indexed bool
+	err := storage.db.Get("tickets", "revokedIndexed", &indexed)
+	if err != nil && err != storm.ErrNotFound {
+		return 0, err
+	}
+	if indexed {
+		return 0, nil
+	}
+	tx, err := storage.db.Begin(true)
+	if err != nil {
+		log.Printf("Cannot begin transaction: %s", err.Error())
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	count := 0
+	var tickets []Ticket
+	err = tx.All(&tickets)
+	if err != nil {
+		return 0, err
+	}
+	for _, ticket := range tickets {
+		if ticket.active() {
+			continue
+		}
+		// saving again updates every index of the ticket
+		if err := tx.Save(&ticket); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	err = tx.Set("tickets", "revokedIndexed", true)
+	if err != nil {
+		return 0, err
+	}
+	return count, tx.Commit()
+}
+
+// TopDiscrepancy top point which doesn't match the invite records
+type TopDiscrepancy struct {
+	UserID  int
+	Name    string
+	Point   int
+	Invites int
+}
+
+// AuditReport result of checking the leaderboard against the invite records
+type AuditReport struct {
+	Tops          int
+	Invites       int
+	Discrepancies []TopDiscrepancy
+	Orphans       []InviteUser
+	Repaired      bool
+}
+
+// Audit recompute every top point from the valid invite records, report the
+// tops which drifted and the invites whose inviter has no top, and fix both if
+// repair. Invites of an inviter who left the group are not valid and don't count
+func (storage *QuestionStorage) Audit(repair bool) (AuditReport, error) {
+	report := AuditReport{}
+	tx, err := storage.db.Begin(repair)
+	if err != nil {
+		log.Printf("Cannot begin transaction: %s", err.Error())
+		return report, err
+	}
+	defer tx.Rollback()
+
+	var invites []InviteUser
+	err = tx.All(&invites)
+	if err != nil {
+		return report, err
+	}
+	var tops []Top
+	err = tx.All(&tops)
+	if err != nil {
+		return report, err
+	}
+	report.Tops = len(tops)
+
+	counts := map[int]int{}
+	for _, invite := range invites {
+		if invite.Valid {
+			report.Invites++
+			counts[invite.UserID]++
+		}
+	}
+	hasTop := map[int]bool{}
+	for _, top := range tops {
+		hasTop[top.ID] = true
+		if top.Point != counts[top.ID] {
+			report.Discrepancies = append(report.Discrepancies, TopDiscrepancy{
+				UserID:  top.ID,
+				Name:    top.Name,
+				Point:   top.Point,
+				Invites: counts[top.ID],
+			})
+		}
+	}
+	for _, invite := range invites {
+		if invite.Valid && !hasTop[invite.UserID] {
+			report.Orphans = append(report.Orphans, invite)
+		}
+	}
+	if !repair || (len(report.Discrepancies) == 0 && len(report.Orphans) == 0) {
+		return report, nil
+	}
+
+	for _, discrepancy := range report.Discrepancies {
+		err = countTop(tx, discrepancy.UserID, discrepancy.Name)
+		if err != nil {
+			log.Printf("Cannot repair top point: %s", err.Error())
+			return report, err
+		}
+	}
+	for _, orphan := range report.Orphans {
+		err = countTop(tx, orphan.UserID, orphan.Name)
+		if err != nil {
+			log.Printf("Cannot repair top point: %s", err.Error())
+			return report, err
+		}
+	}
+	err = tx.Commit()
+	report.Repaired = err == nil
+	return report, err
+}
+
+// ErrStaleAnswer the answer is not for the current question of the user
+var ErrStaleAnswer = errors.New("answer is not for the current question")
+
+// RecordAnswer add the result of an answer to the user score and the attempt and
+// advance to the next question in one transaction, newScore is saved if the
+// user has no score yet
+func (storage *QuestionStorage) RecordAnswer(id int64, index int, newScore Score, answer AttemptAnswer) (Question, error) {
+	var question Question
+	tx, err := storage.db.Begin(true)
+	if err != nil {
+		log.Printf("Cannot begin transaction: %s", err.Error())
+		return question, err
+	}
+	defer tx.Rollback()
+
+	err = tx.One("ID", id, &question)
+	if err != nil {
+		return question, err
+	}
+	if question.CurrentQuestion != index {
+		return question, ErrStaleAnswer
+	}
+	var score Score
+	err = tx.One("ID", newScore.ID, &score)
+	if err == storm.ErrNotFound {
+		score = newScore
+	} else if err != nil {
+		return question, err
+	}
+	// a score saved by a time out has no name yet
+	score.UserName = newScore.UserName
+	score.FirstName = newScore.FirstName
+	score.LastName = newScore.LastName
+	if answer.Correct {
+		score.Score++
+	}
+	score.Points += answer.Points
+	score.Results = append(score.Results, answer.QuestionResult)
+	err = tx.Save(&score)
+	if err != nil {
+		log.Printf("Cannot save score: %s", err.Error())
+		return question, err
+	}
+	err = addAttemptAnswer(tx, question, answer, false)
+	if err != nil {
+		return question, err
+	}
+	question.CurrentQuestion++
+	question.Deadline = 0
+	question.Selected = nil
+	err = tx.Save(&question)
+	if err != nil {
+		log.Printf("Cannot save question: %s", err.Error())
+		return question, err
+	}
+	return question, tx.Commit()
+}
+
+// StartQuestion save the message of the question sent to the user and when it times out
+func (storage *QuestionStorage) StartQuestion(id int64, index int, messageID int, deadline int64) error {
+	tx, err := storage.db.Begin(true)
+	if err != nil {
+		log.Printf("Cannot begin transaction: %s", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	var question Question
+	err = tx.One("ID", id, &question)
+	if err != nil {
+		return err
+	}
+	if question.CurrentQuestion != index {
+		return ErrStaleAnswer
+	}
+	question.MessageID = messageID
+	question.Deadline = deadline
+	question.SentAt = time.Now().Unix()
+	err = tx.Save(&question)
+	if err != nil {
+		log.Printf("Cannot save question: %s", err.Error())
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetLatestQuestionSet get the question set loaded last
+func (storage *QuestionStorage) GetLatestQuestionSet() (QuestionSet, error) {
+	var sets []QuestionSet
+	err := storage.db.All(&sets, storm.Limit(1), storm.Reverse())
+	if err != nil {
+		return QuestionSet{}, err
+	}
+	if len(sets) == 0 {
+		return QuestionSet{}, storm.ErrNotFound
+	}
+	return sets[0], nil
+}
+
+// GetQuestionSet get a question set by version
+func (storage *QuestionStorage) GetQuestionSet(version int) (QuestionSet, error) {
+	var set QuestionSet
+	err := storage.db.One("Version", version, &set)
+	return set, err
+}
+
+// SaveQuestionSet save a new version of the question set
+func (storage *QuestionStorage) SaveQuestionSet(set *QuestionSet) error {
+	err := storage.db.Save(set)
+	if err != nil {
+		log.Printf("Cannot save question set: %s", err.Error())
+	}
+	return err
+}
+
+// StartAttempt save a new attempt
+func (storage *QuestionStorage) StartAttempt(attempt Attempt) error {
+	err := storage.db.Save(&attempt)
+	if err != nil {
+		log.Printf("Cannot save attempt: %s", err.Error())
+	}
+	return err
+}
+
+// GetAttempts get every attempt of the user from the first one
+func (storage *QuestionStorage) GetAttempts(userID int) ([]Attempt, error) {
+	var attempts []Attempt
+	err := storage.db.Find("UserID", userID, &attempts)
+	if err == storm.ErrNotFound {
+		return attempts, nil
+	}
+	return attempts, err
+}
+
+// GetAllAttempts get the attempts of every user
+func (storage *QuestionStorage) GetAllAttempts() ([]Attempt, error) {
+	var attempts []Attempt
+	err := storage.db.All(&attempts)
+	return attempts, err
+}
+
+// addAttemptAnswer add the answer of the current question to the attempt of
+// the session, the attempt is finished after its last question or when finish
+// is set. Sessions started before attempts were recorded have no attempt
+func addAttemptAnswer(tx storm.Node, question Question, answer AttemptAnswer, finish bool) error {
+	var attempt Attempt
+	err := tx.One("Session", question.Session, &attempt)
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	answer.Index = question.CurrentQuestion
+	answer.SentAt = question.SentAt
+	answer.AnsweredAt = time.Now().Unix()
+	attempt.Answers = append(attempt.Answers, answer)
+	if finish || question.CurrentQuestion+1 >= question.count() {
+		attempt.FinishedAt = answer.AnsweredAt
+	}
+	err = tx.Save(&attempt)
+	if err != nil {
+		log.Printf("Cannot save attempt: %s", err.Error())
+	}
+	return err
+}
+
+// ToggleOption select the option of the current question or unselect it if it
+// was selected
+func (storage *QuestionStorage) ToggleOption(id int64, index int, option int) (Question, error) {
+	var question Question
+	tx, err := storage.db.Begin(true)
+	if err != nil {
+		log.Printf("Cannot begin transaction: %s", err.Error())
+		return question, err
+	}
+	defer tx.Rollback()
+
+	err = tx.One("ID", id, &question)
+	if err != nil {
+		return question, err
+	}
+	if question.CurrentQuestion != index {
+		return question, ErrStaleAnswer
+	}
+	selected := []int{}
+	for _, current := range question.Selected {
+		if current != option {
+			selected = append(selected, current)
+		}
+	}
+	if len(selected) == len(question.Selected) {
+		selected = append(selected, option)
+		sort.Ints(selected)
+	}
+	question.Selected = selected
+	err = tx.Save(&question)
+	if err != nil {
+		log.Printf("Cannot save question: %s", err.Error())
+		return question, err
+	}
+	return question, tx.Commit()
+}
+
+// GetTimedQuestions get the questions waiting for an answer with a time limit
+func (storage *QuestionStorage) GetTimedQuestions() ([]Question, error) {
+	var questions []Question
+	err := storage.db.Range("Deadline", int64(1), int64(math.MaxInt64), &questions)
+	if err == storm.ErrNotFound {
+		return questions, nil
+	}
+	return questions, err
+}
+
+// TimeoutQuestion count the current question as wrong and advance to the next
+// one, or past the last one if the quiz time is over
+func (storage *QuestionStorage) TimeoutQuestion(id int64, index int, quizOver bool, answer AttemptAnswer) error {
+	tx, err := storage.db.Begin(true)
+	if err != nil {
+		log.Printf("Cannot begin transaction: %s", err.Error())
+		return err
+	}
+	defer tx.Rollback()
+
+	var question Question
+	err = tx.One("ID", id, &question)
+	if err != nil {
+		return err
+	}
+	if question.CurrentQuestion != index || question.Deadline == 0 {
+		return ErrStaleAnswer
+	}
+	answer.TimedOut = true
+	answer.Points = 0
+	answer.Correct = false
+	// the score counts the question as wrong
+	var score Score
+	err = tx.One("ID", int(id), &score)
+	if err == storm.ErrNotFound {
+		score = Score{ID: int(id), Valid: true}
+	} else if err != nil {
+		return err
+	}
+	score.Results = append(score.Results, answer.QuestionResult)
+	err = tx.Save(&score)
+	if err != nil {
+		log.Printf("Cannot save score: %s", err.Error())
+		return err
+	}
+	err = addAttemptAnswer(tx, question, answer, quizOver)
+	if err != nil {
+		return err
+	}
+	question.CurrentQuestion++
+	if quizOver {
+		question.CurrentQuestion = question.count()
+	}
+	question.Deadline = 0
+	question.Selected = nil
+	err = tx.Save(&question)
+	if err != nil {
+		log.Printf("Cannot save question: %s", err.Error())
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveQuestion remove question list to restart
+func (storage *QuestionStorage) RemoveQuestion(id int64) error {
+	current, err := storage.GetCurrentQuestion(id)
+	if err != nil {
+		log.Printf("Cannot get question.")
+		return err
+	}
+	log.Printf("Remove question: %+v", current)
+	err = storage.db.DeleteStruct(&current)
+	if err != nil {
+		log.Printf("Cannot remove question: %s", err.Error())
+	}
+	return err
+}
+
+// UpdateInviteUser update lucky number to invite user
+func (storage *QuestionStorage) UpdateInviteUser(invitedUser InviteUser) error {
+	err := storage.db.Update(&invitedUser)
+	if err != nil {
+		log.Printf("Cannot update invited user: %s", err.Error())
+	}
+	if invitedUser.Valid == false {
+		err = storage.db.UpdateField(&invitedUser, "Valid", false)
+		if err != nil {
+			log.Printf("Cannot update invited valid: %s", err.Error())
+		}
+	}
+	return err
+}
+
+// GetInvitedUser Get a user
+func (storage *QuestionStorage) GetInvitedUser(userID int) ([]InviteUser, error) {
+	var users []InviteUser
+	err := storage.db.Find("UserID", userID, &users)
+	if err != nil {
+		log.Printf("Cannot get invited user: %s", err.Error())
+	}
+	return users, err
+}
+
+// GetInvitedUserByInvitedID get invited user by invited id
+func (storage *QuestionStorage) GetInvitedUserByInvitedID(invitedID int) (InviteUser, error) {
+	var user InviteUser
+	err := storage.db.One("InvitedID", invitedID, &user)
+	if err != nil {
+		log.Printf("Cannot get invited user")
+	}
+	return user, err
+}
+
+// GetTop get top point
+func (storage *QuestionStorage) GetTop() ([]Top, error) {
+	var tops []Top
+	err := storage.db.AllByIndex("Point", &tops)
+	return tops, err
+}
+
+// GetTopByUserID get top by user id
+func (storage *QuestionStorage) GetTopByUserID(userID int) (Top, error) {
+	var top Top
+	err := storage.db.One("ID", userID, &top)
+	return top, err
+}
+
+// UpdateTopObject update top object
+func (storage *QuestionStorage) UpdateTopObject(top Top) error {
+	err := storage.db.Update(&top)
+	if err != nil {
+		log.Printf("Cannot update top object: %s", err.Error())
+	}
+	if top.Valid == false {
+		err = storage.db.UpdateField(&top, "Valid", false)
+		if err != nil {
+			log.Printf("Cannot update top valid:  %s", err.Error())
+		}
+	}
+	return err
+}
+
+// GetAllInvitedUser Get all invited users
+func (storage *QuestionStorage) GetAllInvitedUser() ([]InviteUser, error) {
+	var users []InviteUser
+	err := storage.db.All(&users)
+	if err != nil {
+		log.Printf("Cannot get all invited user: %s", err.Error())
+	}
+	return users, err
+}
+
+// GetScoreRanking get the valid scores from the most points, ties are ranked by correct answers
+func (storage *QuestionStorage) GetScoreRanking() ([]Score, error) {
+	var scores []Score
+	err := storage.db.All(&scores)
+	if err != nil {
+		return scores, err
+	}
+	ranking := []Score{}
+	for _, score := range scores {
+		if score.Valid {
+			ranking = append(ranking, score)
+		}
+	}
+	sort.SliceStable(ranking, func(i, j int) bool {
+		if ranking[i].Points != ranking[j].Points {
+			return ranking[i].Points > ranking[j].Points
+		}
+		return ranking[i].Score > ranking[j].Score
+	})
+	return ranking, nil
+}
+
+// GetAllUserScore Get all user score
+func (storage *QuestionStorage) GetAllUserScore() ([]Score, error) {
+	var scores []Score
+	err := storage.db.All(&scores)
+	if err != nil {
+		log.Printf("Cannot get all scores: %s", err.Error())
+	}
+	return scores, err
+}
+
+// GetConversation get the dialog state of a user in a chat
+func (storage *QuestionStorage) GetConversation(id string) (Conversation, error) {
+	var conversation Conversation
+	err := storage.db.One("ID", id, &conversation)
+	return conversation, err
+}
+
+// UpdateConversation save the dialog state of a user in a chat
+func (storage *QuestionStorage) UpdateConversation(conversation Conversation) error {
+	return storage.db.Save(&conversation)
+}
+
+// GetAnnouncedPhase get the last phase announced in the chat group
+func (storage *QuestionStorage) GetAnnouncedPhase() (Phase, error) {
+	var phase Phase
+	err := storage.db.Get("phase", "announced", &phase)
+	return phase, err
+}
+
+// UpdateAnnouncedPhase save the last phase announced in the chat group
+func (storage *QuestionStorage) UpdateAnnouncedPhase(phase Phase) error {
+	return storage.db.Set("phase", "announced", phase)
+}
+
+// drawID the campaign only has one draw
+const drawID = 1
+
+// GetDraw get the committed draw
+func (storage *QuestionStorage) GetDraw() (Draw, error) {
+	var draw Draw
+	err := storage.db.One("ID", drawID, &draw)
+	return draw, err
+}
+
+// CommitDraw save the seed of the draw before it is revealed
+func (storage *QuestionStorage) CommitDraw(draw Draw) error {
+	draw.ID = drawID
+	err := storage.db.Save(&draw)
+	if err != nil {
+		log.Printf("Cannot commit draw: %s", err.Error())
+	}
+	return err
+}
+
+// ErrAlreadyDrawn the winners of the draw are saved already
+var ErrAlreadyDrawn = errors.New("draw is drawn already")
+
+// RecordDraw save the winners of the committed draw, once. The check and the
+// save are in one transaction so two admins can't both draw
+func (storage *QuestionStorage) RecordDraw(winners []Winner) (Draw, error) {
+	var draw Draw
+	tx, err := storage.db.Begin(true)
+	if err != nil {
+		log.Printf("Cannot begin transaction: %s", err.Error())
+		return draw, err
+	}
+	defer tx.Rollback()
+
+	err = tx.One("ID", drawID, &draw)
+	if err != nil {
+		return draw, err
+	}
+	if draw.DrawnAt != 0 {
+		return draw, ErrAlreadyDrawn
+	}
+	draw.Winners = winners
+	draw.DrawnAt = time.Now().Unix()
+	err = tx.Save(&draw)
+	if err != nil {
+		log.Printf("Cannot update draw: %s", err.Error())
+		return draw, err
+	}
+	return draw, tx.Commit()
 }
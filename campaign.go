@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultCampaignID id of the campaign built from a config without campaigns section
+const defaultCampaignID = "default"
+
+// maxCampaignID longest campaign id in bytes. Every inline button carries the
+// campaign id in its data, which telegram limits to 64 bytes, and the answer
+// buttons need up to 29 more: "\fanswer|", the session, the index and the option
+const maxCampaignID = 32
+
+// Phase stage of a campaign lifecycle
+type Phase int
+
+const (
+	// PhaseDraft campaign is configured but not started
+	PhaseDraft Phase = iota
+	// PhaseRegistration invites count and numbers can be picked
+	PhaseRegistration
+	// PhaseQuiz the quiz is open
+	PhaseQuiz
+	// PhaseClosed number picking is closed, waiting for the draw
+	PhaseClosed
+	// PhaseDraw lucky numbers can be drawn
+	PhaseDraw
+	// PhaseFinished campaign is over
+	PhaseFinished
+)
+
+var phaseNames = []string{"draft", "registration", "quiz", "closed", "draw", "finished"}
+
+func (p Phase) String() string {
+	if int(p) < len(phaseNames) {
+		return phaseNames[p]
+	}
+	return fmt.Sprintf("phase(%d)", int(p))
+}
+
+// Schedule start time of each phase, a zero registration or quiz time means
+// the phase is open from the beginning, a zero draw time means the draw is
+// right after closed and a zero finished time means the campaign never finishes
+type Schedule struct {
+	Registration int64 `json:"registration"`
+	Quiz         int64 `json:"quiz"`
+	Closed       int64 `json:"closed"`
+	Draw         int64 `json:"draw"`
+	Finished     int64 `json:"finished"`
+}
+
+// PhaseAt phase of the schedule at the time
+func (s Schedule) PhaseAt(now time.Time) Phase {
+	unix := now.Unix()
+	switch {
+	case s.Finished != 0 && unix >= s.Finished:
+		return PhaseFinished
+	case unix >= s.Closed && unix >= s.Draw:
+		return PhaseDraw
+	case unix >= s.Closed:
+		return PhaseClosed
+	case unix >= s.Quiz:
+		return PhaseQuiz
+	case unix >= s.Registration:
+		return PhaseRegistration
+	}
+	return PhaseDraft
+}
+
+func (s Schedule) validate() error {
+	if s.Closed == 0 {
+		return fmt.Errorf("closed time is required")
+	}
+	times := []int64{s.Registration, s.Quiz, s.Closed, s.Draw, s.Finished}
+	last := int64(0)
+	for index, t := range times {
+		if t == 0 {
+			continue
+		}
+		if t < last {
+			return fmt.Errorf("%s time is before the previous phase", Phase(index+1))
+		}
+		last = t
+	}
+	return nil
+}
+
+// QuizConfig how many questions an attempt has and how many correct answers
+// pass the quiz, pass_score takes precedence over pass_percent and the quiz
+// is only passed with all answers correct if neither is set. The time limits
+// and the cooldown between attempts are in seconds, 0 means no limit
+type QuizConfig struct {
+	Questions         int `json:"questions"`
+	PassScore         int `json:"pass_score"`
+	PassPercent       int `json:"pass_percent"`
+	QuestionTimeLimit int `json:"question_time_limit"`
+	TimeLimit         int `json:"time_limit"`
+	MaxAttempts       int `json:"max_attempts"`
+	Cooldown          int `json:"cooldown"`
+	// ShuffleOptions show the options of each question in a random order
+	ShuffleOptions bool `json:"shuffle_options"`
+	// Mix questions of each difficulty in an attempt, by default the
+	// questions are drawn regardless of their difficulty
+	Mix map[Difficulty]int `json:"mix,omitempty"`
+}
+
+// defaultQuizQuestions questions of an attempt when the config doesn't say
+const defaultQuizQuestions = 5
+
+// defaultQuestions questions of an attempt when the config doesn't say, the
+// size of the mix if there is one
+func (q QuizConfig) defaultQuestions() int {
+	if len(q.Mix) == 0 {
+		return defaultQuizQuestions
+	}
+	total := 0
+	for _, count := range q.Mix {
+		total += count
+	}
+	return total
+}
+
+// PassMark correct answers needed to pass the quiz
+func (q QuizConfig) PassMark() int {
+	if q.PassScore > 0 {
+		return q.PassScore
+	}
+	if q.PassPercent > 0 {
+		return (q.Questions*q.PassPercent + 99) / 100
+	}
+	return q.Questions
+}
+
+func (q QuizConfig) validate(questions Questions) error {
+	if q.Questions <= 0 {
+		return fmt.Errorf("quiz must have at least 1 question")
+	}
+	if q.Questions > len(questions) {
+		return fmt.Errorf("quiz has %d questions but only %d questions are loaded", q.Questions, len(questions))
+	}
+	if len(q.Mix) > 0 {
+		pool := questions.byDifficulty()
+		total := 0
+		for _, difficulty := range difficulties {
+			count := q.Mix[difficulty]
+			if count < 0 {
+				return fmt.Errorf("mix has %d %s questions", count, difficulty)
+			}
+			if count > len(pool[difficulty]) {
+				return fmt.Errorf("mix has %d %s questions but only %d are loaded", count, difficulty, len(pool[difficulty]))
+			}
+			total += count
+		}
+		for difficulty := range q.Mix {
+			if !difficulty.valid() {
+				return fmt.Errorf("mix has unknown difficulty %q", difficulty)
+			}
+		}
+		if total != q.Questions {
+			return fmt.Errorf("mix has %d questions but the quiz has %d", total, q.Questions)
+		}
+	}
+	if q.PassScore > q.Questions {
+		return fmt.Errorf("pass score %d is more than %d questions", q.PassScore, q.Questions)
+	}
+	if q.PassPercent < 0 || q.PassPercent > 100 {
+		return fmt.Errorf("pass percent must be between 0 and 100")
+	}
+	if q.QuestionTimeLimit < 0 || q.TimeLimit < 0 {
+		return fmt.Errorf("time limits must not be negative")
+	}
+	if q.MaxAttempts < 0 || q.Cooldown < 0 {
+		return fmt.Errorf("max attempts and cooldown must not be negative")
+	}
+	return nil
+}
+
+// Campaign a quiz run for a chat group, every campaign has its own storage
+type Campaign struct {
+	ID        string       `json:"id" storm:"id"`
+	ChatGroup string       `json:"chatgroup" storm:"index"`
+	Schedule  Schedule     `json:"schedule"`
+	Questions string       `json:"questions"`
+	Quiz      QuizConfig   `json:"quiz"`
+	Numbers   NumberConfig `json:"numbers"`
+	Prizes    []PrizeTier  `json:"prizes"`
+}
+
+// Phase phase of the campaign at the time
+func (c Campaign) Phase(now time.Time) Phase {
+	return c.Schedule.PhaseAt(now)
+}
+
+// inGroup check if the chat username is the group of the campaign, usernames
+// are case insensitive
+func (c Campaign) inGroup(username string) bool {
+	return strings.EqualFold(c.ChatGroup, username)
+}
+
+// Running check if the campaign accepts participants at the time
+func (c Campaign) Running(now time.Time) bool {
+	phase := c.Phase(now)
+	return phase == PhaseRegistration || phase == PhaseQuiz
+}
+
+// campaigns configured campaigns, a config with only deadline and chatgroup
+// is a single campaign
+func (config BotConfig) campaigns() []Campaign {
+	if len(config.Campaigns) != 0 {
+		return config.Campaigns
+	}
+	return []Campaign{
+		{
+			ID:        defaultCampaignID,
+			ChatGroup: config.ChatGroup,
+			Schedule: Schedule{
+				Closed: config.Deadline,
+			},
+			Prizes: config.Prizes,
+		},
+	}
+}
+
+func validateCampaigns(campaigns []Campaign) error {
+	ids := map[string]bool{}
+	for index, campaign := range campaigns {
+		if campaign.ID == "" {
+			return fmt.Errorf("campaign %d: id is required", index)
+		}
+		if len(campaign.ID) > maxCampaignID {
+			return fmt.Errorf("campaign %s: id is longer than %d bytes", campaign.ID, maxCampaignID)
+		}
+		if ids[campaign.ID] {
+			return fmt.Errorf("campaign %s: duplicated id", campaign.ID)
+		}
+		ids[campaign.ID] = true
+		if campaign.ChatGroup == "" {
+			return fmt.Errorf("campaign %s: chatgroup is required", campaign.ID)
+		}
+		if err := campaign.Schedule.validate(); err != nil {
+			return fmt.Errorf("campaign %s: %s", campaign.ID, err.Error())
+		}
+		// a group can only run one campaign at a time
+		for _, other := range campaigns[:index] {
+			if other.inGroup(campaign.ChatGroup) &&
+				other.Schedule.Registration < campaign.Schedule.Closed &&
+				campaign.Schedule.Registration < other.Schedule.Closed {
+				return fmt.Errorf("campaign %s: overlaps campaign %s in @%s", campaign.ID, other.ID, campaign.ChatGroup)
+			}
+		}
+	}
+	return nil
+}
+
+// legacyCampaign campaign which gets the records saved before the storage was
+// scoped per campaign: the default campaign, or the first one
+func legacyCampaign(campaigns []Campaign) Campaign {
+	for _, campaign := range campaigns {
+		if campaign.ID == defaultCampaignID {
+			return campaign
+		}
+	}
+	return campaigns[0]
+}
+
+// currentCampaign pick the running campaign, or the latest started one if
+// none is running, or the first one if none has started
+func currentCampaign(bots []Bot, now time.Time) (Bot, bool) {
+	var current Bot
+	found := false
+	for _, bot := range bots {
+		if bot.campaign.Running(now) {
+			return bot, true
+		}
+		start := bot.campaign.Schedule.Registration
+		if start <= now.Unix() && (!found || start > current.campaign.Schedule.Registration) {
+			current = bot
+			found = true
+		}
+	}
+	if !found && len(bots) > 0 {
+		return bots[0], true
+	}
+	return current, found
+}
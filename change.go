@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// changeButton endpoint of the buttons of /change which pick the ticket to
+// change, the callback data is "campaign|ticket id"
+var changeButton = tb.InlineButton{Unique: "change"}
+
+// releaseButton endpoint of the buttons of /change which release the number
+// of a ticket, the callback data is the same as the change buttons
+var releaseButton = tb.InlineButton{Unique: "release"}
+
+// changeKeys one row for each filled ticket, to change or release its number
+func (b Bot) changeKeys(tickets []Ticket) [][]tb.InlineButton {
+	keys := [][]tb.InlineButton{}
+	for _, ticket := range tickets {
+		if !ticket.active() || ticket.Number == "" {
+			continue
+		}
+		data := b.callbackData(strconv.Itoa(ticket.ID))
+		keys = append(keys, []tb.InlineButton{
+			{
+				Unique: changeButton.Unique,
+				Text:   "Đổi số " + ticket.Number,
+				Data:   data,
+			},
+			{
+				Unique: releaseButton.Unique,
+				Text:   "Bỏ số " + ticket.Number,
+				Data:   data,
+			},
+		})
+	}
+	return keys
+}
+
+// handleChange /change list the numbers picked so the user can change or release one
+func (b Bot) handleChange(m *tb.Message) {
+	if !b.checkPhase(m, PhaseRegistration, PhaseQuiz) {
+		return
+	}
+	if !m.Private() {
+		b.bot.Reply(m, "/change riêng cho Bụt để Bụt đổi số may mắn cho.")
+		return
+	}
+	tickets, err := b.storage.GetTickets(m.Sender.ID)
+	if err != nil {
+		log.Printf("Cannot get tickets: %s", err.Error())
+	}
+	keys := b.changeKeys(tickets)
+	if len(keys) == 0 {
+		b.bot.Send(m.Sender, "Con chưa chọn số may mắn nào để đổi.")
+		return
+	}
+	message := "Các vé con đã chọn số:\n"
+	for _, ticket := range tickets {
+		if ticket.active() && ticket.Number != "" {
+			message += ticket.String() + "\n"
+		}
+	}
+	message += "\nChọn số con muốn đổi hoặc bỏ nhé."
+	b.bot.Send(m.Sender, message, &tb.SendOptions{
+		ParseMode: tb.ModeMarkdown,
+		ReplyMarkup: &tb.ReplyMarkup{
+			InlineKeyboard: keys,
+		},
+	})
+}
+
+// changeTicket ticket of the button, nil if the button can't be used anymore
+func (b Bot) changeTicket(c *tb.Callback) *Ticket {
+	m := callbackMessage(c)
+	if !b.checkPhase(m, PhaseRegistration, PhaseQuiz) {
+		b.bot.Respond(c)
+		return nil
+	}
+	id, err := strconv.Atoi(c.Data)
+	if err != nil {
+		b.bot.Respond(c)
+		return nil
+	}
+	tickets, err := b.storage.GetTickets(c.Sender.ID)
+	if err != nil {
+		log.Printf("Cannot get tickets: %s", err.Error())
+	}
+	for _, ticket := range tickets {
+		if ticket.ID == id && ticket.active() && ticket.Number != "" {
+			return &ticket
+		}
+	}
+	b.bot.Respond(c, &tb.CallbackResponse{
+		Text: "Vé này không còn đổi được nữa.",
+	})
+	return nil
+}
+
+func (b Bot) handleChangeCallback(c *tb.Callback) {
+	ticket := b.changeTicket(c)
+	if ticket == nil {
+		return
+	}
+	b.bot.Respond(c)
+	m := callbackMessage(c)
+	b.updateConversation(Conversation{
+		ID:     conversationID(m),
+		State:  StateAwaitingChange,
+		Ticket: ticket.ID,
+	})
+	b.bot.Send(m.Chat, fmt.Sprintf("Điền %s may mắn mới thay cho số %s: ", b.campaign.Numbers.describe(), ticket.Number))
+}
+
+func (b Bot) handleReleaseCallback(c *tb.Callback) {
+	ticket := b.changeTicket(c)
+	if ticket == nil {
+		return
+	}
+	released, err := b.storage.ChangeTicketNumber(c.Sender.ID, ticket.ID, "", 0)
+	if err != nil {
+		log.Printf("Cannot release ticket %d: %s", ticket.ID, err.Error())
+		b.bot.Respond(c, &tb.CallbackResponse{
+			Text: "Bụt chưa bỏ được số này, con thử lại sau nhé.",
+		})
+		return
+	}
+	b.bot.Respond(c)
+	m := callbackMessage(c)
+	b.updateState(m, StateIdle, "")
+	b.bot.Send(m.Chat, fmt.Sprintf("Bụt đã bỏ số %s, con có thể /add để chọn số khác cho vé này nhé.", released.Changes[len(released.Changes)-1].From))
+}
+
+// handleChangeNumber the new number of the ticket picked with /change
+func (b Bot) handleChangeNumber(m *tb.Message) {
+	if !b.checkPhase(m, PhaseRegistration, PhaseQuiz) {
+		b.updateState(m, StateIdle, "")
+		return
+	}
+	text, ok := b.campaign.Numbers.parse(m.Text)
+	if !ok {
+		b.bot.Reply(m, fmt.Sprintf("Con phải gửi %s thì Bụt mới lưu lại được.", b.campaign.Numbers.describe()))
+		return
+	}
+	conversation := b.conversation(m)
+	ticket, err := b.storage.ChangeTicketNumber(m.Sender.ID, conversation.Ticket, text, b.campaign.Numbers.limit())
+	if err == ErrNumberTaken {
+		b.bot.Reply(m, fmt.Sprintf("Số %s đã có người chọn rồi, con chọn số khác nhé.", text))
+		return
+	}
+	b.updateState(m, StateIdle, "")
+	if err == ErrNoTicket {
+		b.bot.Send(m.Chat, "Vé này không còn đổi được nữa, /change để chọn lại nhé.")
+		return
+	}
+	if err != nil {
+		log.Printf("Cannot change ticket %d: %s", conversation.Ticket, err.Error())
+		b.bot.Send(m.Chat, "Bụt chưa đổi được số, con thử lại sau nhé.")
+		return
+	}
+	change := ticket.Changes[len(ticket.Changes)-1]
+	b.bot.Send(m.Chat, fmt.Sprintf("Bụt đã đổi số may mắn %s thành %s cho con.", change.From, change.To))
+}
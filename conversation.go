@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// ConversationState step of the dialog the bot is waiting for
+type ConversationState int
+
+const (
+	// StateIdle no pending prompt
+	StateIdle ConversationState = iota
+	// StateAwaitingLucky waiting for a lucky number
+	StateAwaitingLucky
+	// StateAwaitingWho waiting for the number to check with /who
+	StateAwaitingWho
+	// StateAwaitingConfirm waiting for /yes or /no on a duplicated number
+	StateAwaitingConfirm
+	// StateAwaitingAnswer waiting for the answer of a text or numeric question
+	StateAwaitingAnswer
+	// StateAwaitingChange waiting for the new number of a ticket picked with /change
+	StateAwaitingChange
+)
+
+// stateExpiry how long a prompt stays valid, afterwards messages are
+// handled as if there was no prompt. StateAwaitingAnswer doesn't expire, a
+// question without time limit waits for its answer as long as the quiz is open
+var stateExpiry = map[ConversationState]time.Duration{
+	StateAwaitingLucky:   time.Hour,
+	StateAwaitingWho:     10 * time.Minute,
+	StateAwaitingConfirm: 10 * time.Minute,
+	StateAwaitingChange:  10 * time.Minute,
+}
+
+func conversationID(m *tb.Message) string {
+	return fmt.Sprintf("%d_%d", m.Chat.ID, m.Sender.ID)
+}
+
+// conversation get the current state of the dialog with the sender
+func (b Bot) conversation(m *tb.Message) Conversation {
+	conversation, err := b.storage.GetConversation(conversationID(m))
+	if err != nil {
+		if err.Error() != "not found" {
+			log.Printf("Cannot get conversation: %s", err.Error())
+		}
+		return Conversation{ID: conversationID(m), State: StateIdle}
+	}
+	if _, expires := stateExpiry[conversation.State]; expires && time.Now().Unix() > conversation.ExpiresAt {
+		return Conversation{ID: conversationID(m), State: StateIdle}
+	}
+	return conversation
+}
+
+// updateState move the dialog with the sender to a new state, number is the
+// lucky number waiting for confirmation
+func (b Bot) updateState(m *tb.Message, state ConversationState, number string) {
+	b.updateConversation(Conversation{
+		ID:     conversationID(m),
+		State:  state,
+		Number: number,
+	})
+}
+
+// updateConversation save the dialog with the sender, the expiry comes from its state
+func (b Bot) updateConversation(conversation Conversation) {
+	if expiry, ok := stateExpiry[conversation.State]; ok {
+		conversation.ExpiresAt = time.Now().Add(expiry).Unix()
+	}
+	if err := b.storage.UpdateConversation(conversation); err != nil {
+		log.Printf("Cannot update conversation: %s", err.Error())
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// newSeed generate a random seed for the draw
+func newSeed() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// seedHash hash which is published before the seed is revealed
+func seedHash(seed string) string {
+	sum := sha256.Sum256([]byte(seed))
+	return hex.EncodeToString(sum[:])
+}
+
+// drawNumbers derive count distinct numbers of the campaign from the seed, the
+// i-th candidate is min plus the first 8 bytes of sha256("seed:i") modulo the
+// size of the range, with 4 digit numbers it is modulo 10000
+func drawNumbers(seed string, count int, config NumberConfig) []string {
+	numbers := []string{}
+	drawn := map[string]bool{}
+	for i := 0; len(numbers) < count; i++ {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", seed, i)))
+		number := config.format(config.Min + int(binary.BigEndian.Uint64(sum[:8])%uint64(config.size())))
+		if drawn[number] {
+			continue
+		}
+		drawn[number] = true
+		numbers = append(numbers, number)
+	}
+	return numbers
+}
+
+func (b Bot) checkAdmin(m *tb.Message) bool {
+	chat, err := b.bot.ChatByID("@" + b.campaign.ChatGroup)
+	if err != nil {
+		log.Printf("Cannot get chat by id %s: %s", b.campaign.ChatGroup, err.Error())
+		return false
+	}
+	member, err := b.bot.ChatMemberOf(chat, m.Sender)
+	if err != nil {
+		log.Printf("Cannot get chat member of: %s", err.Error())
+		return false
+	}
+	return member.Role == tb.Creator || member.Role == tb.Administrator
+}
+
+func (b Bot) announce(message string) {
+	chat, err := b.bot.ChatByID("@" + b.campaign.ChatGroup)
+	if err != nil {
+		log.Printf("Cannot get chat by id %s: %s", b.campaign.ChatGroup, err.Error())
+		return
+	}
+	_, err = b.bot.Send(chat, message, &tb.SendOptions{
+		ParseMode: tb.ModeMarkdown,
+	})
+	if err != nil {
+		log.Printf("Cannot announce to %s: %s", b.campaign.ChatGroup, err.Error())
+	}
+}
+
+func (b Bot) handleDraw(m *tb.Message) {
+	if !b.checkAdmin(m) {
+		return
+	}
+	if strings.TrimSpace(m.Payload) == "commit" {
+		b.handleDrawCommit(m)
+		return
+	}
+	draw, err := b.storage.GetDraw()
+	if err != nil {
+		b.bot.Send(m.Sender, "Chưa commit seed quay số, dùng /draw commit trước khi hết hạn chương trình.")
+		return
+	}
+	if !b.inPhase(PhaseDraw, PhaseFinished) {
+		b.bot.Send(m.Sender, "Chưa đến thời gian quay số.")
+		return
+	}
+	if draw.DrawnAt == 0 {
+		winners, err := b.drawWinners(draw.Seed)
+		if err != nil {
+			b.bot.Send(m.Sender, "Không thể quay số, thử lại sau.")
+			return
+		}
+		draw, err = b.storage.RecordDraw(winners)
+		if err == ErrAlreadyDrawn {
+			// an other admin drew at the same time and announced it
+			b.bot.Send(m.Sender, "Bụt đã quay số rồi, kết quả đã được công bố trong group.")
+			return
+		}
+		if err != nil {
+			b.bot.Send(m.Sender, "Không thể lưu kết quả quay số, thử lại sau.")
+			return
+		}
+	}
+	b.announce(drawMessage(draw, b.campaign.Numbers))
+}
+
+// drawWinners resolve the lottery prizes from the seed and the top prizes from the leaderboard
+func (b Bot) drawWinners(seed string) ([]Winner, error) {
+	winners := []Winner{}
+	lottery := tiersOf(b.campaign.Prizes, PoolLottery)
+	numbers := drawNumbers(seed, countPrizes(lottery), b.campaign.Numbers)
+	rank := 0
+	for _, tier := range lottery {
+		for i := 0; i < tier.Count; i++ {
+			number := numbers[rank]
+			rank++
+			users, err := b.storage.Who(number)
+			if err != nil && err.Error() != "not found" {
+				log.Printf("Cannot get winners of %s: %s", number, err.Error())
+				return winners, err
+			}
+			winners = append(winners, Winner{
+				Rank:   rank,
+				Tier:   tier.Name,
+				Pool:   PoolLottery,
+				Number: number,
+				Users:  users,
+			})
+		}
+	}
+	tops, err := b.storage.GetTop()
+	if err != nil && err.Error() != "not found" {
+		log.Printf("Cannot get top: %s", err.Error())
+		return winners, err
+	}
+	// tops are sorted by point ascending
+	index := len(tops) - 1
+	for _, tier := range tiersOf(b.campaign.Prizes, PoolTop) {
+		for i := 0; i < tier.Count; i++ {
+			for index >= 0 && !tops[index].Valid {
+				index--
+			}
+			if index < 0 {
+				break
+			}
+			top := tops[index]
+			index--
+			rank++
+			winners = append(winners, Winner{
+				Rank:  rank,
+				Tier:  tier.Name,
+				Pool:  PoolTop,
+				Users: []User{NewUser(top.ID, top.Name, "")},
+			})
+		}
+	}
+	return winners, nil
+}
+
+func (b Bot) handleDrawCommit(m *tb.Message) {
+	draw, err := b.storage.GetDraw()
+	if err == nil {
+		b.bot.Send(m.Sender, fmt.Sprintf("Seed đã được commit, mã băm: %s", draw.SeedHash))
+		return
+	}
+	if !b.inPhase(PhaseDraft, PhaseRegistration, PhaseQuiz) {
+		b.bot.Send(m.Sender, "Đã hết thời gian chọn số, không thể commit seed nữa.")
+		return
+	}
+	seed, err := newSeed()
+	if err != nil {
+		log.Printf("Cannot generate seed: %s", err.Error())
+		return
+	}
+	draw = Draw{
+		Seed:        seed,
+		SeedHash:    seedHash(seed),
+		CommittedAt: time.Now().Unix(),
+	}
+	if err := b.storage.CommitDraw(draw); err != nil {
+		b.bot.Send(m.Sender, "Không thể lưu seed quay số, thử lại sau.")
+		return
+	}
+	b.announce(fmt.Sprintf("Mã băm SHA-256 của seed quay số may mắn là: `%s`\nSeed sẽ được công bố khi quay số để mọi người có thể kiểm tra.", draw.SeedHash))
+}
+
+func drawMessage(draw Draw, numbers NumberConfig) string {
+	message := "🎉 Kết quả quay số may mắn:\n"
+	tier := ""
+	for _, winner := range draw.Winners {
+		if winner.Tier != tier {
+			tier = winner.Tier
+			message += fmt.Sprintf("\n⭐️ Giải \"%s\":\n", tier)
+		}
+		if winner.Pool == PoolTop {
+			user := winner.Users[0]
+			message += fmt.Sprintf("%d. [%s](tg://user?id=%d)\n", winner.Rank, strings.TrimSpace(user.Name), user.ID)
+			continue
+		}
+		message += fmt.Sprintf("%d. Số %s: ", winner.Rank, winner.Number)
+		if len(winner.Users) == 0 {
+			message += "chưa có ai chọn\n"
+			continue
+		}
+		names := []string{}
+		for _, user := range winner.Users {
+			names = append(names, fmt.Sprintf("[%s](tg://user?id=%d) (%s)", strings.TrimSpace(user.Name), user.ID, user.LuckyNumber))
+		}
+		message += strings.Join(names, ", ") + "\n"
+	}
+	message += fmt.Sprintf("\nSeed: `%s`\nMã băm đã công bố: `%s`\n", draw.Seed, draw.SeedHash)
+	message += fmt.Sprintf("Số thứ i là 8 byte đầu của sha256(\"seed:i\") chia lấy dư cho %d", numbers.size())
+	if numbers.Min > 0 {
+		message += fmt.Sprintf(" rồi cộng %d", numbers.Min)
+	}
+	message += ", bỏ qua số trùng."
+	return message
+}
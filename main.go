@@ -6,7 +6,7 @@ import (
 	"io/ioutil"
 	"log"
 	"math/rand"
-	"regexp"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -16,34 +16,48 @@ import (
 
 // BotConfig config for bot
 type BotConfig struct {
-	Key       string `json:"bot_key"`
-	Deadline  int64  `json:"deadline"`
-	ChatGroup string `json:"chatgroup"`
+	Key       string      `json:"bot_key"`
+	Deadline  int64       `json:"deadline"`
+	ChatGroup string      `json:"chatgroup"`
+	Prizes    []PrizeTier `json:"prizes"`
+	Campaigns []Campaign  `json:"campaigns"`
 }
 
-// Bot object
+// Bot object, one for each campaign
 type Bot struct {
-	bot      *tb.Bot
-	storage  *QuestionStorage
-	deadline int64
+	bot       *tb.Bot
+	storage   *QuestionStorage
+	campaign  Campaign
+	questions *questionSets
+	locks     *userLocks
+}
+
+// QuizQuestion a question of the quiz
+type QuizQuestion struct {
+	// ID stable id of the question, attempts refer to questions by id
+	ID       string       `json:"id"`
+	Type     QuestionType `json:"type,omitempty"`
+	Question string       `json:"question"`
+	// Difficulty medium if not set
+	Difficulty Difficulty `json:"difficulty,omitempty"`
+	// Points earned by a correct answer, 1 if not set
+	Points float64 `json:"points,omitempty"`
+	// Photo url or path of a photo sent with the question
+	Photo   string   `json:"photo,omitempty"`
+	Options []string `json:"options,omitempty"`
+	// Answer correct option of a single choice question
+	Answer int `json:"answer"`
+	// Answers correct options of a multi choice question
+	Answers []int `json:"answers,omitempty"`
+	// Accepted accepted answers of a text question
+	Accepted []string `json:"accepted,omitempty"`
+	// Number and Tolerance the answer of a numeric question
+	Number    float64 `json:"number,omitempty"`
+	Tolerance float64 `json:"tolerance,omitempty"`
 }
 
 // Questions question list
-type Questions []struct {
-	Question string   `json:"question"`
-	Options  []string `json:"options"`
-	Answer   int      `json:"answer"`
-}
-
-// const for chat group
-var chatGroup string
-
-// map[chatID]questionID
-var questions Questions
-var lucky map[string]string
-var selectedNumber map[string]string
-var replyKeysTwo [][]tb.ReplyButton
-var replyKeysFour [][]tb.ReplyButton
+type Questions []QuizQuestion
 
 func readConfigFromFile(path string) (BotConfig, error) {
 	data, err := ioutil.ReadFile(path)
@@ -55,22 +69,33 @@ func readConfigFromFile(path string) (BotConfig, error) {
 	return result, err
 }
 
-func readQuestionsFromFile(path string) (Questions, error) {
-	data, err := ioutil.ReadFile(path)
-	if err != nil {
-		return Questions{}, err
-	}
-	result := Questions{}
-	err = json.Unmarshal(data, &result)
-	return result, err
+const (
+	// configPath path of the bot config
+	configPath = "./config.json"
+	// defaultQuestionsPath questions of a campaign without questions file
+	defaultQuestionsPath = "./questions.json"
+)
 
+// commands cli subcommands, the bot runs when no subcommand is given
+var commands = map[string]func(args []string) error{
+	"audit":    runAudit,
+	"validate": runValidate,
+	"qstats":   runQStats,
 }
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile)
-	path := "./config.json"
-	botConfig, err := readConfigFromFile(path)
-	chatGroup = botConfig.ChatGroup
+	if len(os.Args) > 1 {
+		command, ok := commands[os.Args[1]]
+		if !ok {
+			log.Fatalf("Unknown command: %s", os.Args[1])
+		}
+		if err := command(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	botConfig, err := readConfigFromFile(configPath)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -82,108 +107,147 @@ func main() {
 		log.Fatalf("Cannot initiate new bot: %s", err.Error())
 	}
 	storage, err := NewBoltStorage()
-	mybot := Bot{
-		bot:      tbot,
-		storage:  storage,
-		deadline: botConfig.Deadline,
-	}
 	if err != nil {
 		log.Panic(err)
 		return
 	}
 
-	questionPath := "./questions.json"
-	questions, err = readQuestionsFromFile(questionPath)
-	if err != nil {
+	campaigns := botConfig.campaigns()
+	if err := validateCampaigns(campaigns); err != nil {
 		log.Fatal(err)
 	}
+	// before the tickets of the campaign are migrated from its lucky numbers
+	legacy := legacyCampaign(campaigns)
+	moved, err := storage.MigrateLegacy(legacy.ID)
+	if err != nil {
+		log.Fatalf("Campaign %s: cannot migrate records saved before campaigns: %s", legacy.ID, err.Error())
+	}
+	if moved > 0 {
+		log.Printf("Campaign %s: migrated %d records saved before campaigns", legacy.ID, moved)
+	}
+	router := &Router{
+		bot:     tbot,
+		storage: storage,
+	}
+	for _, campaign := range campaigns {
+		if len(campaign.Prizes) == 0 {
+			campaign.Prizes = defaultPrizes
+		}
+		campaign.Numbers = campaign.Numbers.withDefaults()
+		if err := campaign.Numbers.validate(); err != nil {
+			log.Fatalf("Campaign %s: %s", campaign.ID, err.Error())
+		}
+		if err := validatePrizes(campaign.Prizes, campaign.Numbers); err != nil {
+			log.Fatalf("Campaign %s: %s", campaign.ID, err.Error())
+		}
+		if campaign.Questions == "" {
+			campaign.Questions = defaultQuestionsPath
+		}
+		if campaign.Quiz.Questions == 0 {
+			campaign.Quiz.Questions = campaign.Quiz.defaultQuestions()
+		}
+		if err := storage.SaveCampaign(campaign); err != nil {
+			log.Fatal(err)
+		}
+		questions, err := newQuestionSets(storage.Campaign(campaign.ID))
+		if err != nil {
+			log.Fatalf("Campaign %s: %s", campaign.ID, err.Error())
+		}
+		bot := Bot{
+			bot:       tbot,
+			storage:   storage.Campaign(campaign.ID),
+			campaign:  campaign,
+			questions: questions,
+			locks:     &router.locks,
+		}
+		if _, _, err := bot.reloadQuestions(); err != nil {
+			log.Fatalf("Campaign %s: %s", campaign.ID, err.Error())
+		}
+		migrated, err := bot.storage.MigrateTickets(campaign.Quiz.PassMark())
+		if err != nil {
+			log.Fatalf("Campaign %s: cannot migrate tickets: %s", campaign.ID, err.Error())
+		}
+		if migrated > 0 {
+			log.Printf("Campaign %s: migrated %d tickets", campaign.ID, migrated)
+		}
+		indexed, err := bot.storage.IndexRevokedTickets()
+		if err != nil {
+			log.Fatalf("Campaign %s: cannot index revoked tickets: %s", campaign.ID, err.Error())
+		}
+		if indexed > 0 {
+			log.Printf("Campaign %s: indexed %d revoked tickets", campaign.ID, indexed)
+		}
+		router.bots = append(router.bots, bot)
+		go bot.schedule()
+		go bot.watchTimers()
+	}
+	go reloadOnSignal(router.bots)
 
-	replyKeysFour = mybot.initReplyKeys([]string{"A", "B", "C", "D"})
-	replyKeysTwo = mybot.initReplyKeys([]string{"A", "B"})
+	tbot.Handle("/start", router.handleStart)
 
-	mybot.bot.Handle("/start", func(m *tb.Message) {
-		mybot.handleStart(m)
-	})
+	router.handleCallback(&answerButton, Bot.handleAnswerCallback)
+	router.handleCallback(&toggleButton, Bot.handleToggleCallback)
 
-	mybot.bot.Handle("/A", func(m *tb.Message) {
-		mybot.handleAnswer(m, 0)
-	})
-	mybot.bot.Handle("/B", func(m *tb.Message) {
-		mybot.handleAnswer(m, 1)
-	})
-	mybot.bot.Handle("/C", func(m *tb.Message) {
-		mybot.handleAnswer(m, 2)
-	})
-	mybot.bot.Handle("/D", func(m *tb.Message) {
-		mybot.handleAnswer(m, 3)
-	})
+	router.handle("/who", Bot.handleWho)
 
-	mybot.bot.Handle("/who", func(m *tb.Message) {
-		mybot.handleWho(m)
-	})
+	router.handle("/me", Bot.handleMe)
 
-	mybot.bot.Handle("/me", func(m *tb.Message) {
-		mybot.handleMe(m)
-	})
+	router.handle("/add", Bot.handleAdd)
 
-	mybot.bot.Handle("/add", func(m *tb.Message) {
-		mybot.handleAdd(m)
-	})
+	router.handle(tb.OnText, Bot.handleText)
 
-	mybot.bot.Handle(tb.OnText, func(m *tb.Message) {
-		mybot.handleText(m)
-	})
+	router.handle(tb.OnUserJoined, Bot.handleUserJoined)
 
-	mybot.bot.Handle(tb.OnUserJoined, func(m *tb.Message) {
-		mybot.handleUserJoined(m)
-	})
+	router.handle(tb.OnUserLeft, Bot.handleUserLeft)
 
-	mybot.bot.Handle(tb.OnUserLeft, func(m *tb.Message) {
-		mybot.handleUserLeft(m)
-	})
+	router.handle("/top", Bot.handleTop)
 
-	mybot.bot.Handle("/top", func(m *tb.Message) {
-		mybot.handleTop(m)
-	})
+	router.handle("/help", Bot.handleHelp)
 
-	mybot.bot.Handle("/help", func(m *tb.Message) {
-		mybot.handleHelp(m)
-	})
+	router.handle("/prize", Bot.handlePrize)
 
-	mybot.bot.Handle("/prize", func(m *tb.Message) {
-		mybot.handlePrize(m)
-	})
+	router.handle("/yes", Bot.handleYes)
 
-	mybot.bot.Handle("/yes", func(m *tb.Message) {
-		mybot.handleYes(m)
-	})
+	router.handle("/no", Bot.handleNo)
 
-	mybot.bot.Handle("/no", func(m *tb.Message) {
-		mybot.handleNo(m)
-	})
+	router.handle("/close", Bot.handleClose)
 
-	mybot.bot.Handle("/close", func(m *tb.Message) {
-		mybot.handleClose(m)
-	})
+	router.handle("/stat", Bot.handleStat)
 
-	mybot.bot.Handle("/stat", func(m *tb.Message) {
-		mybot.handleStat(m)
-	})
+	router.handle("/draw", Bot.handleDraw)
 
-	mybot.bot.Start()
-}
+	router.handle("/audit", Bot.handleAudit)
 
-func (b Bot) handlePrize(m *tb.Message) {
-	message := fmt.Sprintf(`Con thân mến, cơ cấu giải thưởng của chương trình như sau:
+	router.handle("/attempts", Bot.handleAttempts)
+
+	router.handle("/qstats", Bot.handleQStats)
+
+	router.handle("/reload", Bot.handleReload)
 
-		⭐️️️ Ta có *15 giải* cho những người có vé số may mắn trong đó:
+	router.handle("/bonus", Bot.handleBonus)
 
-			💰 5 Giải đặc biệt mỗi giải 100 KNC
-			💰 10 Giải "suýt đặc biệt" mỗi giải 10 KNC
-		
-		⭐ Ngoài ra còn có *5 Giải* "cống hiến" mỗi giải là 40 KNC dành cho 5 thành viên mời được nhiều bạn tham gia nhất
+	router.handle("/change", Bot.handleChange)
+	router.handleCallback(&changeButton, Bot.handleChangeCallback)
+	router.handleCallback(&releaseButton, Bot.handleReleaseCallback)
+	router.handleCallback(&pickButton, Bot.handlePickCallback)
 
-	Chúc con may mắn 😉`)
+	tbot.Start()
+}
+
+func (b Bot) handlePrize(m *tb.Message) {
+	message := "Con thân mến, cơ cấu giải thưởng của chương trình như sau:\n\n"
+	lottery := tiersOf(b.campaign.Prizes, PoolLottery)
+	if len(lottery) > 0 {
+		message += fmt.Sprintf("⭐️️️ Ta có *%d giải* cho những người có vé số may mắn trong đó:\n", countPrizes(lottery))
+		for _, tier := range lottery {
+			message += fmt.Sprintf("    💰 %s\n", tier)
+		}
+		message += "\n"
+	}
+	for _, tier := range tiersOf(b.campaign.Prizes, PoolTop) {
+		message += fmt.Sprintf("⭐ Ngoài ra còn có *%s* dành cho %d thành viên mời được nhiều bạn tham gia nhất\n", tier, tier.Count)
+	}
+	message += "\nChúc con may mắn 😉"
 	b.bot.Send(m.Chat, message, &tb.SendOptions{
 		// ParseMode: tb.ModeMarkdown,
 	})
@@ -191,30 +255,17 @@ func (b Bot) handlePrize(m *tb.Message) {
 
 func (b Bot) handleHelp(m *tb.Message) {
 	message := fmt.Sprintf(`Chào con, Bụt đây.
-	Con có thể /start để bắt đầu trả lời câu hỏi. Trả lời đúng hết cả 5 câu hỏi, Bụt sẽ thưởng cho con 1 "vé" để chọn số may mắn.
+	Con có thể /start để bắt đầu trả lời câu hỏi. Trả lời đúng %d/%d câu hỏi, Bụt sẽ thưởng cho con 1 "vé" để chọn số may mắn.
 	Con có thể mời bạn bè vào @%s, để được tặng thêm "vé" may mắn, tăng khả năng trúng thưởng nhé.
 	   
 	/me để xem lại số vé may mắn con đã chọn,
+	/change để đổi hoặc bỏ số may mắn đã chọn,
 	/top để xem xem ai mời nhiều nhất nè
 	/who [số] để kiểm tra xem có ai chọn trùng số không.
-	/prize để xem danh sách quà tặng của Bụt nhé.`, chatGroup)
+	/prize để xem danh sách quà tặng của Bụt nhé.`, b.campaign.Quiz.PassMark(), b.campaign.Quiz.Questions, b.campaign.ChatGroup)
 	b.bot.Send(m.Chat, message)
 }
 
-func updateCurrentCommand(command string, m *tb.Message) {
-	if len(lucky) == 0 {
-		lucky = map[string]string{}
-	}
-	lucky[fmt.Sprintf("%d_%d", m.Chat.ID, m.Sender.ID)] = command
-}
-
-func updateSelectedNumber(lucky string, m *tb.Message) {
-	if len(selectedNumber) == 0 {
-		selectedNumber = map[string]string{}
-	}
-	selectedNumber[fmt.Sprintf("%d_%d", m.Chat.ID, m.Sender.ID)] = lucky
-}
-
 func (b Bot) activateUser(userID int) error {
 	// activate score
 	score, err := b.storage.GetUserScore(userID)
@@ -222,21 +273,12 @@ func (b Bot) activateUser(userID int) error {
 		score.Valid = true
 		b.storage.UpdateScore(userID, score)
 	}
-	// activate invite member
-	inviteUsers, err := b.storage.GetInvitedUser(userID)
-	if err == nil {
-		for _, user := range inviteUsers {
-			user.Valid = true
-			b.storage.UpdateInviteUser(user)
-		}
-	}
-	// activate top score
-	top, err := b.storage.GetTopByUserID(userID)
-	if err == nil {
-		top.Valid = true
-		b.storage.UpdateTopObject(top)
+	// activate tickets
+	if err := b.storage.UpdateTicketsValid(userID, true); err != nil {
+		log.Printf("Cannot activate tickets: %s", err.Error())
 	}
-	return err
+	// activate invite members and top score
+	return b.storage.UpdateInvitesValid(userID, true)
 }
 
 func (b Bot) deactivateUser(userID int) error {
@@ -247,46 +289,31 @@ func (b Bot) deactivateUser(userID int) error {
 		score.Valid = false
 		b.storage.UpdateScore(userID, score)
 	}
-	// deactivate invite member
-	inviteUsers, err := b.storage.GetInvitedUser(userID)
-	if err == nil {
-		for _, user := range inviteUsers {
-			user.Valid = false
-			b.storage.UpdateInviteUser(user)
-		}
-	}
-	// deactivate top score
-	top, err := b.storage.GetTopByUserID(userID)
-	if err == nil {
-		top.Valid = false
-		b.storage.UpdateTopObject(top)
+	// deactivate tickets
+	if err := b.storage.UpdateTicketsValid(userID, false); err != nil {
+		log.Printf("Cannot deactivate tickets: %s", err.Error())
 	}
-	return err
+	// deactivate invite members and top score
+	return b.storage.UpdateInvitesValid(userID, false)
 }
 
-func (b Bot) checkAlreadyInvited(user tb.User, m *tb.Message) {
-	invitedUser, err := b.storage.GetInvitedUserByInvitedID(user.ID)
-	if err == nil {
-		if invitedUser.UserID != m.Sender.ID {
-			b.storage.RemoveUser(user.ID)
-			b.storage.UpdateTop(invitedUser.UserID, invitedUser.Name, -1)
-			name := strings.TrimSpace(invitedUser.InvitedName)
-			message := fmt.Sprintf("Bạn [%s](tg://user?id=%d đã rời khỏi group và được mời lại bởi 1 người khác, số may mắn con chọn cho bạn này không còn giá trị nữa.", name, invitedUser.InvitedID)
-			user := &tb.User{
-				ID: invitedUser.UserID,
-			}
-			b.bot.Send(user, message, &tb.SendOptions{
-				ParseMode: tb.ModeMarkdown,
-			})
-		}
+// notifyTransfer tell the previous inviter that the user was invited again by someone else
+func (b Bot) notifyTransfer(previous InviteUser) {
+	name := strings.TrimSpace(previous.InvitedName)
+	message := fmt.Sprintf("Bạn [%s](tg://user?id=%d) đã rời khỏi group và được mời lại bởi 1 người khác, số may mắn con chọn cho bạn này không còn giá trị nữa.", name, previous.InvitedID)
+	user := &tb.User{
+		ID: previous.UserID,
 	}
+	b.bot.Send(user, message, &tb.SendOptions{
+		ParseMode: tb.ModeMarkdown,
+	})
 }
 
 func (b Bot) handleUserJoined(m *tb.Message) {
-	if time.Now().Unix() > b.deadline {
+	if !b.inPhase(PhaseRegistration, PhaseQuiz) {
 		return
 	}
-	if m.Chat.Username != chatGroup {
+	if !b.campaign.inGroup(m.Chat.Username) {
 		return
 	}
 	if m.Sender.ID == m.UserJoined.ID {
@@ -294,11 +321,10 @@ func (b Bot) handleUserJoined(m *tb.Message) {
 		return
 	}
 	message := "Con đã add "
+	invited := 0
 	for _, user := range m.UsersJoined {
-		b.checkAlreadyInvited(user, m)
 		name := fmt.Sprintf("%s %s", m.Sender.FirstName, m.Sender.LastName)
 		invitedName := fmt.Sprintf("%s %s", user.FirstName, user.LastName)
-		message += fmt.Sprintf("[%s](tg://user?id=%d)", invitedName, m.UserJoined.ID)
 		inviteUser := InviteUser{
 			UserID:          m.Sender.ID,
 			InvitedID:       user.ID,
@@ -309,13 +335,32 @@ func (b Bot) handleUserJoined(m *tb.Message) {
 			InvitedName:     invitedName,
 			Valid:           true,
 		}
-		b.storage.InvitedUser(m.Sender.ID, inviteUser)
-		b.storage.UpdateTop(m.Sender.ID, name, 1)
+		err := b.storage.RecordInvite(inviteUser)
+		if err == ErrAlreadyInvited {
+			var previous InviteUser
+			previous, err = b.storage.TransferInvite(inviteUser)
+			if err == nil {
+				b.notifyTransfer(previous)
+			}
+		}
+		if err != nil {
+			if err != ErrAlreadyInvited {
+				log.Printf("Cannot record invite: %s", err.Error())
+			}
+			continue
+		}
+		if invited > 0 {
+			message += ", "
+		}
+		message += fmt.Sprintf("[%s](tg://user?id=%d)", invitedName, user.ID)
+		invited++
+	}
+	if invited > 0 {
+		message += fmt.Sprintf(" vào group @%s. Con được thêm %d lần chọn số may mắn. Con có thể /add để thêm số may mắn nhé.", b.campaign.ChatGroup, invited)
+		b.bot.Send(m.Sender, message, &tb.SendOptions{
+			ParseMode: tb.ModeMarkdown,
+		})
 	}
-	message += fmt.Sprintf(" vào group @%s. Con được thêm %d lần chọn số may mắn. Con có thể /add để thêm số may mắn nhé.", chatGroup, len(m.UsersJoined))
-	b.bot.Send(m.Sender, message, &tb.SendOptions{
-		ParseMode: tb.ModeMarkdown,
-	})
 
 	// update valid if this user used to be in the group (and join the campaign)
 	for _, user := range m.UsersJoined {
@@ -333,64 +378,51 @@ func (b Bot) handleMe(m *tb.Message) {
 		b.bot.Reply(m, "Bụt sẽ trả lời riêng cho con.")
 	}
 	message := ""
-	inviteMessage := []string{}
-	invites, err := b.storage.GetInvitedUser(m.Sender.ID)
-	log.Printf("Score: %+v", score)
-	if (score.ID != 0 && score.Valid == false) || (err == nil && invites[0].Valid == false) {
-		message += fmt.Sprintf("Rất tiếc con đã rời khỏi group @%s. Kết quả dưới đây của con không được tính. \n", chatGroup)
-	}
-	if score.Score == 5 {
-		message += fmt.Sprintf("Con đã trả lời chính xác %d/5 câu hỏi và số may mắn con đã chọn là: %s\n", score.Score, score.LuckyNumber)
-	} else {
-		message += fmt.Sprintf("Con đã trả lời chính xác %d/5 câu hỏi, con chưa được chọn số may mắn.\n", score.Score)
+	tickets, err := b.storage.GetTickets(m.Sender.ID)
+	if err != nil {
+		log.Printf("Cannot get tickets: %s", err.Error())
+	}
+	if score.Valid == false {
+		message += fmt.Sprintf("Rất tiếc con đã rời khỏi group @%s. Kết quả dưới đây của con không được tính. \n", b.campaign.ChatGroup)
 	}
-	if err != nil && err.Error() == "not found" {
-		message += fmt.Sprintf("Con hãy mời thêm người bạn nào vào @%s để nhận được thêm vé may mắn nhé 🤗. \n", chatGroup)
+	if b.passed(score) {
+		message += fmt.Sprintf("Con đã trả lời chính xác %d/%d câu hỏi (%g điểm).\n", score.Score, b.campaign.Quiz.Questions, score.Points)
 	} else {
-		message += fmt.Sprintf("Con đã mời: \n")
-		for index, user := range invites {
-			name := strings.TrimSpace(user.InvitedName)
-			if len(inviteMessage) < (index/100)+1 {
-				inviteMessage = append(inviteMessage, "")
-			}
-			inviteMessage[index/100] += fmt.Sprintf("[%s](tg://user?id=%d), số may mắn: %s \n", name, user.InvitedID, user.LuckyNumber)
-		}
+		message += fmt.Sprintf("Con đã trả lời chính xác %d/%d câu hỏi (%g điểm), con chưa được chọn số may mắn.\n", score.Score, b.campaign.Quiz.Questions, score.Points)
 	}
-	_, err = b.storage.GetInvitedUserWithoutLuckyNumber(m.Sender.ID)
-	if err == nil {
-		message += fmt.Sprintf("Con có thể /add để thêm số may mắn.")
+	message += b.attemptsText(m.Sender.ID)
+	ticketMessages := ticketsText(tickets)
+	if len(ticketMessages) == 0 {
+		message += fmt.Sprintf("Con hãy mời thêm người bạn nào vào @%s để nhận được thêm vé may mắn nhé 🤗. \n", b.campaign.ChatGroup)
+	}
+	if openTickets(tickets) > 0 {
+		message += fmt.Sprintf("Con còn %d vé, /add để chọn số may mắn nhé.", openTickets(tickets))
 	}
 	b.bot.Send(m.Sender, message, &tb.SendOptions{
 		ParseMode: tb.ModeMarkdown,
 	})
-	if len(inviteMessage) > 0 {
-		for _, me := range inviteMessage {
-			b.bot.Send(m.Sender, me, &tb.SendOptions{
-				ParseMode: tb.ModeMarkdown,
-			})
-		}
+	for _, me := range ticketMessages {
+		b.bot.Send(m.Sender, me, &tb.SendOptions{
+			ParseMode: tb.ModeMarkdown,
+		})
 	}
 }
 
 func (b Bot) handleAdd(m *tb.Message) {
-	if time.Now().Unix() > b.deadline {
-		b.bot.Reply(m, "Bụt rất tiếc, thời gian tham gia chương trình đã hết.")
+	if !b.checkPhase(m, PhaseRegistration, PhaseQuiz) {
 		return
 	}
 	if !m.Private() {
 		b.bot.Reply(m, "/add riêng cho Bụt để Bụt thêm số may mắn cho.")
 		return
 	}
-	score, _ := b.storage.GetUserScore(m.Sender.ID)
-	if score.Score == 5 && score.LuckyNumber == "" {
-		updateCurrentCommand("invited", m)
-		b.bot.Send(m.Sender, "Điền 4 chữ số may mắn: ")
-		return
+	tickets, err := b.storage.GetTickets(m.Sender.ID)
+	if err != nil {
+		log.Printf("Cannot get tickets: %s", err.Error())
 	}
-	_, err := b.storage.GetInvitedUserWithoutLuckyNumber(m.Sender.ID)
-	if err == nil {
-		updateCurrentCommand("invited", m)
-		b.bot.Send(m.Sender, "Điền 4 chữ số may mắn: ")
+	if open := openTickets(tickets); open > 0 {
+		b.updateState(m, StateAwaitingLucky, "")
+		b.bot.Send(m.Sender, fmt.Sprintf("Điền %s may mắn hoặc để Bụt chọn giúp con: ", b.campaign.Numbers.describe()), b.pickOptions(open))
 	} else {
 		b.bot.Send(m.Sender, "Con không còn vé nào để chọn số may mắn.")
 	}
@@ -423,7 +455,7 @@ func (b Bot) handleTop(m *tb.Message) {
 }
 
 func (b Bot) handleUserLeft(m *tb.Message) {
-	if m.Chat.Username != chatGroup {
+	if !b.campaign.inGroup(m.Chat.Username) {
 		return
 	}
 	b.deactivateUser(m.UserLeft.ID)
@@ -433,17 +465,15 @@ func (b Bot) handleUserLeft(m *tb.Message) {
 		receiver = tb.User{
 			ID: m.UserLeft.ID,
 		}
-		message = fmt.Sprintf("Sao con lại rời khỏi group @%s. Buồn quá, Bụt phải cho con ra khỏi danh sách nhận quà rồi 😢", chatGroup)
+		message = fmt.Sprintf("Sao con lại rời khỏi group @%s. Buồn quá, Bụt phải cho con ra khỏi danh sách nhận quà rồi 😢", b.campaign.ChatGroup)
 	} else {
-		exist, err := b.storage.GetInvitedUserByInvitedID(m.UserLeft.ID)
+		exist, err := b.storage.RevokeInvite(m.UserLeft.ID)
 		if err == nil {
-			b.storage.RemoveUser(m.UserLeft.ID)
 			message = fmt.Sprintf("[%s](tg://user?id=%d) đã rời khỏi group @%s. Số may mắn con chọn cho [%s](tg://user?id=%d) đã không còn hiệu lực nữa.",
-				exist.InvitedName, exist.InvitedID, chatGroup, exist.InvitedName, exist.InvitedID)
+				exist.InvitedName, exist.InvitedID, b.campaign.ChatGroup, exist.InvitedName, exist.InvitedID)
 			receiver = tb.User{
 				ID: exist.UserID,
 			}
-			b.storage.UpdateTop(exist.UserID, exist.Username, -1)
 		}
 	}
 	b.bot.Send(&receiver, message, &tb.SendOptions{
@@ -451,37 +481,16 @@ func (b Bot) handleUserLeft(m *tb.Message) {
 	})
 }
 
-func (b Bot) initReplyKeys(questionOptions []string) [][]tb.ReplyButton {
-	replyKeys := [][]tb.ReplyButton{}
-	replyKeyOne := []tb.ReplyButton{}
-	for key := range questionOptions {
-		replyBtn := tb.ReplyButton{Text: questionOptions[key]}
-		b.bot.Handle(&replyBtn, func(m *tb.Message) {
-			option := 0
-			for i, v := range questionOptions {
-				if v == replyBtn.Text {
-					option = i
-				}
-			}
-			b.handleAnswer(m, option)
-		})
-		replyKeyOne = append(replyKeyOne, replyBtn)
-		if key%2 == 1 {
-			replyKeys = append(replyKeys, replyKeyOne)
-			replyKeyOne = []tb.ReplyButton{}
-		}
-	}
-	return replyKeys
-}
-
 func (b Bot) handleText(m *tb.Message) {
-	switch lucky[fmt.Sprintf("%d_%d", m.Chat.ID, m.Sender.ID)] {
-	case "lucky":
-		b.handleUpdateLucky(m)
-	case "who":
-		b.handleCheckWho(m, m.Text)
-	case "invited":
+	switch b.conversation(m).State {
+	case StateAwaitingLucky:
 		b.handleInvited(m)
+	case StateAwaitingWho:
+		b.handleCheckWho(m, m.Text)
+	case StateAwaitingAnswer:
+		b.handleTextAnswer(m)
+	case StateAwaitingChange:
+		b.handleChangeNumber(m)
 	default:
 		b.handleDefault(m)
 	}
@@ -494,13 +503,9 @@ func (b Bot) handleDefault(m *tb.Message) {
 }
 
 func (b Bot) checkDuplicate(userID int, lucky string) bool {
-	score, _ := b.storage.GetUserScore(userID)
-	if score.LuckyNumber == lucky {
-		return true
-	}
-	invitedUsers, _ := b.storage.GetInvitedUser(userID)
-	for _, user := range invitedUsers {
-		if user.LuckyNumber == lucky {
+	tickets, _ := b.storage.GetTickets(userID)
+	for _, ticket := range tickets {
+		if ticket.active() && ticket.Number == lucky {
 			return true
 		}
 	}
@@ -509,7 +514,7 @@ func (b Bot) checkDuplicate(userID int, lucky string) bool {
 
 func (b Bot) handleDuplicate(m *tb.Message, lucky string) {
 	message := "Con đã chọn số này, con có chắc vẫn muốn chọn số này lần nữa? /yes để tiếp tục chọn /no để chọn lại số khác."
-	updateSelectedNumber(lucky, m)
+	b.updateState(m, StateAwaitingConfirm, lucky)
 	b.bot.Reply(m, message)
 }
 
@@ -517,118 +522,51 @@ func (b Bot) handleYes(m *tb.Message) {
 	if !m.Private() {
 		return
 	}
-	if len(selectedNumber) == 0 {
+	if !b.checkPhase(m, PhaseRegistration, PhaseQuiz) {
 		return
 	}
-	lucky := selectedNumber[fmt.Sprintf("%d_%d", m.Chat.ID, m.Sender.ID)]
-	if lucky == "" {
+	conversation := b.conversation(m)
+	if conversation.State != StateAwaitingConfirm {
 		return
 	}
-	invitedUser, err := b.storage.GetInvitedUserWithoutLuckyNumber(m.Sender.ID)
-	if err != nil {
-		log.Printf("Cannot get invited: %s", err.Error())
-	}
-	invitedUser[0].LuckyNumber = lucky
-	err = b.storage.UpdateInviteUser(invitedUser[0])
-	if err != nil {
-		log.Printf("Cannot update lucky number: %s", err.Error())
-	}
-	message := fmt.Sprintf("Số may mắn con đã chọn là: %s, Bụt sẽ quay số may mắn và thông báo người trúng thưởng khi chương trình kết thúc nhé. ", lucky)
-	if len(invitedUser) > 1 {
-		message += fmt.Sprintf("Con còn %d vé, /add để chọn số may mắn nhé.", len(invitedUser)-1)
-	}
-	b.bot.Send(m.Chat, message)
+	lucky := conversation.Number
+	b.updateState(m, StateIdle, "")
+	b.fillTicket(m, lucky)
 }
 
 func (b Bot) handleNo(m *tb.Message) {
-	lucky := selectedNumber[fmt.Sprintf("%d_%d", m.Chat.ID, m.Sender.ID)]
-	if lucky == "" {
+	if b.conversation(m).State != StateAwaitingConfirm {
 		return
 	}
-	updateSelectedNumber("", m)
-	updateCurrentCommand("invited", m)
+	b.updateState(m, StateAwaitingLucky, "")
 	message := "Số con chọn đã bị hủy, hãy chọn số may mắn mới."
 	b.bot.Reply(m, message)
 }
 
 func (b Bot) handleInvited(m *tb.Message) {
-	text := strings.TrimSpace(m.Text)
-	matched, err := regexp.MatchString(`^\d{4,4}$`, text)
-	if err != nil {
-		log.Printf("Cannot match: %s", err.Error())
+	if !b.checkPhase(m, PhaseRegistration, PhaseQuiz) {
+		return
 	}
-	if !matched {
-		b.bot.Reply(m, fmt.Sprintf("Con phải gửi 4 chữ số thì Bụt mới lưu lại được."))
+	text, ok := b.campaign.Numbers.parse(m.Text)
+	if !ok {
+		b.bot.Reply(m, fmt.Sprintf("Con phải gửi %s thì Bụt mới lưu lại được.", b.campaign.Numbers.describe()))
 	} else {
-		if b.checkDuplicate(m.Sender.ID, text) {
+		// a number can't be picked twice when it is unique, FillTicket refuses it
+		if b.campaign.Numbers.limit() != 1 && b.checkDuplicate(m.Sender.ID, text) {
 			b.handleDuplicate(m, text)
-			updateCurrentCommand("", m)
 			return
 		}
-		score, _ := b.storage.GetUserScore(m.Sender.ID)
-		invitedUser, err := b.storage.GetInvitedUserWithoutLuckyNumber(m.Sender.ID)
-		if err != nil {
-			log.Printf("Cannot get invited: %s", err.Error())
-		}
-		if score.Score == 5 && score.LuckyNumber == "" {
-			score.LuckyNumber = text
-			b.storage.UpdateScore(m.Sender.ID, score)
-		} else {
-			invitedUser[0].LuckyNumber = text
-			err = b.storage.UpdateInviteUser(invitedUser[0])
-			if err != nil {
-				log.Printf("Cannot update lucky number: %s", err.Error())
-			}
-		}
-		message := fmt.Sprintf("Số may mắn con đã chọn là: %s, Bụt sẽ quay số may mắn và thông báo người trúng thưởng khi chương trình kết thúc nhé. ", text)
-		if len(invitedUser) > 1 {
-			message += fmt.Sprintf("Con còn %d vé, /add để chọn số may mắn nhé.", len(invitedUser)-1)
-		}
-		b.bot.Send(m.Chat, message)
-		updateCurrentCommand("", m)
-	}
-}
-
-func (b Bot) handleUpdateLucky(m *tb.Message) {
-	if time.Now().Unix() > b.deadline {
-		b.bot.Reply(m, "Bụt rất tiếc, thời gian tham gia chương trình đã hết.")
-		return
-	}
-	text := strings.TrimSpace(m.Text)
-	matched, err := regexp.MatchString(`^\d{4,4}$`, text)
-	if err != nil {
-		log.Printf("Cannot match: %s", err.Error())
-	}
-	if !matched {
-		b.bot.Reply(m, fmt.Sprintf("Con phải gửi 4 chữ số thì Bụt mới lưu lại được."))
-	} else {
-		score, err := b.storage.GetUserScore(m.Sender.ID)
-		if err != nil {
-			log.Printf("Cannot get score: %s", err.Error())
-		}
-		score.LuckyNumber = text
-		err = b.storage.UpdateScore(m.Sender.ID, score)
-		if err != nil {
-			log.Printf("Cannot update lucky number: %s", err.Error())
-		}
-		score, _ = b.storage.GetUserScore(m.Sender.ID)
-		message := fmt.Sprintf("Số may mắn con đã chọn là: %s, bụt sẽ quay số may mắn và thông báo người trúng thưởng khi chương trình kết thúc.", score.LuckyNumber)
-		message += fmt.Sprintf("Con hãy mời thêm bạn nào vào @%s để nhận được thêm vé may mắn nhé 🤗.", chatGroup)
-		b.bot.Send(m.Chat, message)
-		updateCurrentCommand("", m)
+		b.updateState(m, StateIdle, "")
+		b.fillTicket(m, text)
 	}
 }
 
 func (b Bot) handleCheckWho(m *tb.Message, luckyNumber string) {
-	luckyStr := strings.TrimSpace(luckyNumber)
-	matched, err := regexp.MatchString(`^\d{4,4}$`, luckyStr)
-	if err != nil {
-		log.Printf("Cannot match lucky string: %s", err.Error())
-	}
-	if !matched {
-		b.bot.Reply(m, fmt.Sprintf("Con phải gửi 4 chữ số thì Bụt mới tìm được."))
+	luckyStr, ok := b.campaign.Numbers.parse(luckyNumber)
+	if !ok {
+		b.bot.Reply(m, fmt.Sprintf("Con phải gửi %s thì Bụt mới tìm được.", b.campaign.Numbers.describe()))
 	} else {
-		updateCurrentCommand("", m)
+		b.updateState(m, StateIdle, "")
 		users, err := b.storage.Who(luckyStr)
 		if err != nil && err.Error() != "not found" {
 			log.Printf("Cannot get user: %s", err)
@@ -642,12 +580,8 @@ func (b Bot) handleCheckWho(m *tb.Message, luckyNumber string) {
 			if users[0].LuckyNumber == luckyStr {
 				message = fmt.Sprintf("Danh sách những người đã chọn số %s: \n\n", luckyStr)
 			} else {
+				// Who returns everyone of the nearest number, of both numbers on a tie
 				message = fmt.Sprintf("Chưa có ai chọn số %s, người chọn gần nhất là: \n\n", luckyStr)
-				newLucky := users[0].LuckyNumber
-				users, err = b.storage.Who(newLucky)
-				if err != nil && err.Error() != "not found" {
-					log.Printf("Cannot get user: %s", err.Error())
-				}
 			}
 		} else {
 			message = fmt.Sprintf("Chưa có ai trong danh sách.")
@@ -665,84 +599,97 @@ func (b Bot) handleCheckWho(m *tb.Message, luckyNumber string) {
 func (b Bot) next(m *tb.Message) {
 	currentQuestion, _ := b.storage.GetCurrentQuestion(m.Chat.ID)
 	nextQuestion := currentQuestion.CurrentQuestion
-	rands := currentQuestion.Rands
 
-	if nextQuestion+1 > len(rands) {
+	if nextQuestion+1 > currentQuestion.count() {
 		b.finish(m)
 	} else {
-		question := questions[rands[nextQuestion]]
-
-		message := fmt.Sprintf("%d. %s \n\n", nextQuestion+1, question.Question)
-		questionOptions := []string{"A", "B", "C", "D"}
-		for key, option := range question.Options {
-			message += fmt.Sprintf("/%s %s \n", questionOptions[key], option)
+		question := b.shownQuestion(currentQuestion, nextQuestion)
+		deadline := b.questionDeadline(currentQuestion)
+		sent, err := b.sendQuestion(m.Sender, currentQuestion, question, deadline)
+		if err != nil {
+			log.Printf("Cannot send question: %s", err.Error())
+			return
 		}
-		replyKeys := replyKeysFour
-		if len(question.Options) == 2 {
-			replyKeys = replyKeysTwo
+		err = b.storage.StartQuestion(m.Chat.ID, nextQuestion, sent.ID, deadline)
+		if err != nil {
+			log.Printf("Cannot start question: %s", err.Error())
+		}
+		if question.typed() {
+			b.updateState(m, StateAwaitingAnswer, "")
 		}
-		b.bot.Send(m.Sender, message, &tb.SendOptions{
-			DisableWebPagePreview: true,
-			ParseMode:             tb.ModeMarkdown,
-			ReplyMarkup: &tb.ReplyMarkup{
-				ReplyKeyboard:       replyKeys,
-				ResizeReplyKeyboard: true,
-				OneTimeKeyboard:     true,
-			},
-		})
 	}
 }
 
 func (b Bot) finish(m *tb.Message) {
 	score, _ := b.storage.GetUserScore(m.Sender.ID)
-	message := fmt.Sprintf("Con đã trả lời đúng: %d/5 câu hỏi.\n", score.Score)
-	if score.Score == 5 {
-		message += fmt.Sprintf("Thông minh quá. Nhập 4 chữ số để Bụt quay số may mắn nào.")
-		updateCurrentCommand("lucky", m)
+	currentQuestion, _ := b.storage.GetCurrentQuestion(m.Chat.ID)
+	message := fmt.Sprintf("Con đã trả lời đúng: %d/%d câu hỏi, được %g/%g điểm.\n", score.Score, b.campaign.Quiz.Questions, score.Points, b.maxPoints(currentQuestion))
+	options := &tb.SendOptions{}
+	if b.passed(score) {
+		open := b.issueQuizTicket(m, score)
+		if open > 0 {
+			message += fmt.Sprintf("Thông minh quá. Nhập %s để Bụt quay số may mắn nào, hoặc để Bụt chọn giúp con.", b.campaign.Numbers.describe())
+			b.updateState(m, StateAwaitingLucky, "")
+			options = b.pickOptions(open)
+		} else {
+			message += fmt.Sprintf("Thông minh quá. Con đã chọn số may mắn cho vé trả lời câu hỏi rồi, /me để xem lại nhé.")
+		}
 	} else {
-		message += fmt.Sprintf("Tiếc quá cơ, con chưa trả lời được cả 5 câu hỏi. Thử lại để đạt mức điểm cao hơn: /start")
+		message += fmt.Sprintf("Tiếc quá cơ, con chưa trả lời đúng được %d câu hỏi. Thử lại để đạt mức điểm cao hơn: /start", b.campaign.Quiz.PassMark())
 	}
 
-	b.bot.Send(m.Chat, message,
-		&tb.SendOptions{
-			ReplyMarkup: &tb.ReplyMarkup{
-				ReplyKeyboardRemove: true,
-			},
-		})
+	b.bot.Send(m.Chat, message, options)
 }
 
-func (b Bot) handleAnswer(m *tb.Message, option int) {
-	currentQuestion, _ := b.storage.GetCurrentQuestion(m.Chat.ID)
-	current := questions[currentQuestion.Rands[currentQuestion.CurrentQuestion]]
-	if option+1 > len(current.Options) {
-		b.bot.Send(m.Chat, fmt.Sprintf("Câu hỏi không có phương án con chọn."))
+// handleAnswer record the answer of the current question and send the next one
+func (b Bot) handleAnswer(m *tb.Message, current Question, answer AttemptAnswer) {
+	score := Score{
+		ID:        m.Sender.ID,
+		Score:     0,
+		UserName:  m.Sender.Username,
+		FirstName: m.Sender.FirstName,
+		LastName:  m.Sender.LastName,
+		Valid:     true,
+	}
+	_, err := b.storage.RecordAnswer(m.Chat.ID, current.CurrentQuestion, score, answer)
+	if err == ErrStaleAnswer {
+		return
+	}
+	if err != nil {
+		log.Printf("Cannot record answer: %s", err.Error())
 		return
 	}
+	b.next(m)
+}
 
-	score, err := b.storage.GetUserScore(m.Sender.ID)
-	if err != nil && err.Error() == "not found" {
-		score = Score{
-			ID:        m.Sender.ID,
-			Score:     0,
-			UserName:  m.Sender.Username,
-			FirstName: m.Sender.FirstName,
-			LastName:  m.Sender.LastName,
-			Valid:     true,
-		}
+// attemptAnswer answer of the current question which earned the points
+func (b Bot) attemptAnswer(current Question, points float64) AttemptAnswer {
+	question := b.questionAt(current, current.CurrentQuestion)
+	permutation := []int(nil)
+	if current.CurrentQuestion < len(current.Permutations) {
+		permutation = current.Permutations[current.CurrentQuestion]
 	}
-	if option == current.Answer {
-		score.Score++
+	return AttemptAnswer{
+		Permutation: permutation,
+		QuestionResult: QuestionResult{
+			Question:   question.ID,
+			Difficulty: question.difficulty(),
+			Points:     points,
+			MaxPoints:  question.points(),
+			Correct:    points >= question.points(),
+		},
 	}
-	b.storage.UpdateScore(m.Sender.ID, score)
-	currentQuestion.CurrentQuestion++
-	b.storage.UpdateQuestion(m.Chat.ID, currentQuestion)
-	b.next(m)
+}
+
+// passed check if the score passes the quiz and earns a lucky number
+func (b Bot) passed(score Score) bool {
+	return score.Score >= b.campaign.Quiz.PassMark()
 }
 
 func (b Bot) checkRequirement(m *tb.Message) bool {
-	chat, err := b.bot.ChatByID("@" + chatGroup)
+	chat, err := b.bot.ChatByID("@" + b.campaign.ChatGroup)
 	if err != nil {
-		log.Printf("Cannot get chat by id %s: %s", chatGroup, err.Error())
+		log.Printf("Cannot get chat by id %s: %s", b.campaign.ChatGroup, err.Error())
 		return false
 	}
 	qualified, err := b.bot.ChatMemberOf(chat, m.Sender)
@@ -757,8 +704,7 @@ func (b Bot) checkRequirement(m *tb.Message) bool {
 }
 
 func (b Bot) handleStart(m *tb.Message) {
-	if time.Now().Unix() > b.deadline {
-		b.bot.Reply(m, "Bụt rất tiếc, thời gian tham gia chương trình đã hết.")
+	if !b.checkPhase(m, PhaseQuiz) {
 		return
 	}
 	// make sure user chat private to answer the question
@@ -770,15 +716,19 @@ func (b Bot) handleStart(m *tb.Message) {
 	// make sure user joined require group to answer the question
 	qualified := b.checkRequirement(m)
 	if !qualified {
-		b.bot.Send(m.Chat, fmt.Sprintf("Con cần tham gia group @%s để có thể tham gia chương trình.", chatGroup))
+		b.bot.Send(m.Chat, fmt.Sprintf("Con cần tham gia group @%s để có thể tham gia chương trình.", b.campaign.ChatGroup))
+		return
+	}
+	if !b.checkAttempts(m) {
 		return
 	}
 
-	message := "Con chỉ cần trả lời đúng 5 câu hỏi đơn giản của Bụt để được tham gia bốc thăm may mắn."
+	message := fmt.Sprintf("Con chỉ cần trả lời đúng %d/%d câu hỏi đơn giản của Bụt để được tham gia bốc thăm may mắn.", b.campaign.Quiz.PassMark(), b.campaign.Quiz.Questions)
 	b.bot.Send(m.Chat, message)
 	// random a new sequence of question
 	rand.Seed(time.Now().UnixNano())
-	rands := rand.Perm(10)[:5]
+	set := b.questions.latest()
+	questionIDs := b.pickQuestions(set.Questions)
 
 	// remove question
 	b.storage.RemoveQuestion(m.Chat.ID)
@@ -786,9 +736,22 @@ func (b Bot) handleStart(m *tb.Message) {
 	// update new question
 	currentQuestion, _ := b.storage.GetCurrentQuestion(m.Chat.ID)
 	currentQuestion.ID = m.Chat.ID
-	currentQuestion.Rands = rands
+	currentQuestion.QuestionIDs = questionIDs
+	currentQuestion.Permutations = b.shuffleOptions(set.Questions, questionIDs)
 	currentQuestion.CurrentQuestion = 0
+	currentQuestion.Version = set.Version
+	currentQuestion.Session = strconv.FormatInt(time.Now().UnixNano(), 36)
+	if b.campaign.Quiz.TimeLimit > 0 {
+		currentQuestion.QuizDeadline = time.Now().Unix() + int64(b.campaign.Quiz.TimeLimit)
+	}
 	b.storage.UpdateQuestion(m.Chat.ID, currentQuestion)
+	b.storage.StartAttempt(Attempt{
+		UserID:    m.Sender.ID,
+		Session:   currentQuestion.Session,
+		Version:   set.Version,
+		Questions: questionIDs,
+		StartedAt: time.Now().Unix(),
+	})
 
 	// reset score
 	b.storage.RemoveScore(m.Sender.ID)
@@ -801,7 +764,7 @@ func (b Bot) handleWho(m *tb.Message) {
 	payload := m.Payload
 	if payload == "" {
 		if m.Private() {
-			updateCurrentCommand("who", m)
+			b.updateState(m, StateAwaitingWho, "")
 			b.bot.Reply(m, "Con muốn kiểm người may mắn cho số nào?")
 		} else {
 			b.bot.Reply(m, "Sử dụng cú pháp /who [số] để kiểm tra số may mắn trong group nhé")
@@ -812,9 +775,9 @@ func (b Bot) handleWho(m *tb.Message) {
 }
 
 func (b Bot) handleClose(m *tb.Message) {
-	chat, err := b.bot.ChatByID("@" + chatGroup)
+	chat, err := b.bot.ChatByID("@" + b.campaign.ChatGroup)
 	if err != nil {
-		log.Printf("Cannot get chat by id %s: %s", chatGroup, err.Error())
+		log.Printf("Cannot get chat by id %s: %s", b.campaign.ChatGroup, err.Error())
 		return
 	}
 	qualified, err := b.bot.ChatMemberOf(chat, m.Sender)
@@ -840,14 +803,10 @@ func (b Bot) handleClose(m *tb.Message) {
 				if member.Role == tb.Creator || member.Role == tb.Administrator || member.Role == tb.Member {
 					continue
 				}
-				score.Valid = false
-				b.storage.UpdateScore(score.ID, score)
-				top, err := b.storage.GetTopByUserID(score.ID)
-				if err == nil {
-					top.Valid = false
-					b.storage.UpdateTopObject(top)
+				if err := b.deactivateUser(score.ID); err != nil {
+					log.Printf("Cannot deactivate %d: %s", score.ID, err.Error())
 				}
-				message := fmt.Sprintf("Sao con lại rời khỏi group @%s. Buồn quá, Bụt phải cho con ra khỏi danh sách nhận quà rồi 😢", chatGroup)
+				message := fmt.Sprintf("Sao con lại rời khỏi group @%s. Buồn quá, Bụt phải cho con ra khỏi danh sách nhận quà rồi 😢", b.campaign.ChatGroup)
 				b.bot.Send(u, message)
 			}
 		}
@@ -868,11 +827,13 @@ func (b Bot) handleClose(m *tb.Message) {
 				if member.Role == tb.Creator || member.Role == tb.Administrator || member.Role == tb.Member {
 					continue
 				}
-				b.storage.RemoveUser(user.InvitedID)
-				b.storage.UpdateTop(user.UserID, user.Name, -1)
+				if _, err := b.storage.RevokeInvite(user.InvitedID); err != nil {
+					log.Printf("Cannot revoke invite of %d: %s", user.InvitedID, err.Error())
+					continue
+				}
 				name := strings.TrimSpace(user.InvitedName)
 				message := fmt.Sprintf("[%s](tg://user?id=%d) đã rời khỏi group @%s. Số may mắn con chọn cho [%s](tg://user?id=%d) đã không còn hiệu lực nữa.",
-					name, user.InvitedID, chatGroup, name, user.InvitedID)
+					name, user.InvitedID, b.campaign.ChatGroup, name, user.InvitedID)
 				receiver := &tb.User{
 					ID: user.UserID,
 				}
@@ -885,10 +846,13 @@ func (b Bot) handleClose(m *tb.Message) {
 	b.bot.Send(m.Sender, "Đã remove xong những user không thuộc group.")
 }
 
+// statRankingSize scores shown in the ranking of /stat
+const statRankingSize = 10
+
 func (b Bot) handleStat(m *tb.Message) {
-	chat, err := b.bot.ChatByID("@" + chatGroup)
+	chat, err := b.bot.ChatByID("@" + b.campaign.ChatGroup)
 	if err != nil {
-		log.Printf("Cannot get chat by id %s: %s", chatGroup, err.Error())
+		log.Printf("Cannot get chat by id %s: %s", b.campaign.ChatGroup, err.Error())
 		return
 	}
 	qualified, err := b.bot.ChatMemberOf(chat, m.Sender)
@@ -905,9 +869,25 @@ func (b Bot) handleStat(m *tb.Message) {
 			inviteUsers, _ := b.storage.GetAllInvitedUser()
 			inviteNumber := len(inviteUsers)
 
-			message := fmt.Sprintf("Số lượng user tham gia trả lời câu hỏi: %d", scoreNumber)
-			message += fmt.Sprintf("Số lượng user đã được invite vào group: %d", inviteNumber)
-			b.bot.Send(m.Chat, message)
+			message := fmt.Sprintf("Số lượng user tham gia trả lời câu hỏi: %d\n", scoreNumber)
+			message += fmt.Sprintf("Số lượng user đã được invite vào group: %d\n", inviteNumber)
+
+			ranking, err := b.storage.GetScoreRanking()
+			if err != nil {
+				log.Printf("Cannot get score ranking: %s", err.Error())
+			}
+			if len(ranking) > 0 {
+				message += "\nXếp hạng điểm:\n"
+			}
+			for index, score := range ranking {
+				if index == statRankingSize {
+					break
+				}
+				message += fmt.Sprintf("%d. [%s](tg://user?id=%d) - %g điểm, đúng %d câu\n", index+1, score.UserName, score.ID, score.Points, score.Score)
+			}
+			b.bot.Send(m.Chat, message, &tb.SendOptions{
+				ParseMode: tb.ModeMarkdown,
+			})
 		} else {
 			payload := strings.TrimSpace(payload)
 			userID, err := strconv.Atoi(payload)
@@ -918,13 +898,11 @@ func (b Bot) handleStat(m *tb.Message) {
 			score, _ := b.storage.GetUserScore(userID)
 			message := ""
 			if score.Valid {
-				message += fmt.Sprintf("[%s](tg://user?id=%d) đã ghi được %d điểm, số may mắn: %s", score.UserName, score.ID, score.Score, score.LuckyNumber)
+				message += fmt.Sprintf("[%s](tg://user?id=%d) đã trả lời đúng %d câu, ghi được %g điểm.\n", score.UserName, score.ID, score.Score, score.Points)
 
-				inviteUsers, _ := b.storage.GetInvitedUser(userID)
-				for _, user := range inviteUsers {
-					if user.Valid {
-						message += fmt.Sprintf("[%s](tg://user?id=%d) - %s", user.InvitedName, user.InvitedID, user.LuckyNumber)
-					}
+				tickets, _ := b.storage.GetTickets(userID)
+				for _, text := range ticketsText(tickets) {
+					message += text
 				}
 			} else {
 				message += "Người dùng này đã rời khỏi group."
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NumberPolicy how many tickets may pick the same lucky number
+type NumberPolicy string
+
+const (
+	// PolicyAllow any number of tickets share a number
+	PolicyAllow NumberPolicy = "allow"
+	// PolicyUnique the first ticket to pick a number takes it
+	PolicyUnique NumberPolicy = "unique"
+	// PolicyCap at most cap tickets share a number
+	PolicyCap NumberPolicy = "cap"
+)
+
+// defaultNumberDigits digits of a lucky number when the config doesn't say
+const defaultNumberDigits = 4
+
+// maxNumberDigits digits of the longest lucky number, so every number fits an int
+const maxNumberDigits = 9
+
+// NumberConfig lucky numbers of a campaign, a number has exactly digits
+// digits, zero padded, and is between min and max. By default it is any
+// 4 digit number and tickets may share numbers
+type NumberConfig struct {
+	Digits int          `json:"digits"`
+	Min    int          `json:"min"`
+	Max    int          `json:"max"`
+	Policy NumberPolicy `json:"policy"`
+	Cap    int          `json:"cap"`
+}
+
+// withDefaults fill what the config doesn't say
+func (n NumberConfig) withDefaults() NumberConfig {
+	if n.Digits == 0 {
+		n.Digits = defaultNumberDigits
+	}
+	if n.Max == 0 && n.Digits > 0 && n.Digits <= maxNumberDigits {
+		n.Max = pow10(n.Digits) - 1
+	}
+	if n.Policy == "" {
+		n.Policy = PolicyAllow
+	}
+	return n
+}
+
+func pow10(digits int) int {
+	value := 1
+	for i := 0; i < digits; i++ {
+		value *= 10
+	}
+	return value
+}
+
+func (n NumberConfig) validate() error {
+	if n.Digits < 1 || n.Digits > maxNumberDigits {
+		return fmt.Errorf("numbers must have between 1 and %d digits", maxNumberDigits)
+	}
+	if n.Min < 0 || n.Min > n.Max || n.Max >= pow10(n.Digits) {
+		return fmt.Errorf("numbers range %d-%d doesn't fit %d digits", n.Min, n.Max, n.Digits)
+	}
+	switch n.Policy {
+	case PolicyAllow, PolicyUnique:
+	case PolicyCap:
+		if n.Cap < 1 {
+			return fmt.Errorf("numbers policy cap needs a cap of at least 1")
+		}
+	default:
+		return fmt.Errorf("unknown numbers policy %q", n.Policy)
+	}
+	return nil
+}
+
+// size how many numbers can be picked
+func (n NumberConfig) size() int {
+	return n.Max - n.Min + 1
+}
+
+// limit tickets which may share a number, 0 for no limit
+func (n NumberConfig) limit() int {
+	switch n.Policy {
+	case PolicyUnique:
+		return 1
+	case PolicyCap:
+		return n.Cap
+	}
+	return 0
+}
+
+// format the number as picked, zero padded
+func (n NumberConfig) format(value int) string {
+	return fmt.Sprintf("%0*d", n.Digits, value)
+}
+
+// parse check the text is a number of the campaign, it returns the number as stored
+func (n NumberConfig) parse(text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	if len(text) != n.Digits {
+		return "", false
+	}
+	for _, c := range text {
+		if c < '0' || c > '9' {
+			return "", false
+		}
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil || value < n.Min || value > n.Max {
+		return "", false
+	}
+	return n.format(value), true
+}
+
+// describe what a number looks like, for the prompts
+func (n NumberConfig) describe() string {
+	if n.Min == 0 && n.Max == pow10(n.Digits)-1 {
+		return fmt.Sprintf("%d chữ số", n.Digits)
+	}
+	return fmt.Sprintf("%d chữ số từ %s đến %s", n.Digits, n.format(n.Min), n.format(n.Max))
+}
+
+// pickBuckets parts of the number space compared to find the least crowded one
+const pickBuckets = 10
+
+// pick a random number from the least crowded part of the number space,
+// preferring numbers nobody picked. counts are the tickets of each number,
+// false if every number is taken as many times as the policy allows
+func (n NumberConfig) pick(counts map[string]int) (string, bool) {
+	size := n.size()
+	width := (size + pickBuckets - 1) / pickBuckets
+	buckets := (size + width - 1) / width
+	crowd := make([]int, buckets)
+	for number, count := range counts {
+		value, err := strconv.Atoi(number)
+		if err != nil || value < n.Min || value > n.Max {
+			continue
+		}
+		crowd[(value-n.Min)/width] += count
+	}
+	order := rand.Perm(buckets)
+	// the last bucket may be narrower, compare the tickets for each number
+	density := func(bucket int) float64 {
+		return float64(crowd[bucket]) / float64(n.bucketWidth(bucket, width))
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return density(order[i]) < density(order[j])
+	})
+	limit := n.limit()
+	for _, bucket := range order {
+		low := n.Min + bucket*width
+		bucketWidth := n.bucketWidth(bucket, width)
+		start := rand.Intn(bucketWidth)
+		best := ""
+		bestCount := 0
+		// probe from a random number, an unused one is found after at most
+		// as many steps as numbers used in the bucket
+		for i := 0; i < bucketWidth; i++ {
+			number := n.format(low + (start+i)%bucketWidth)
+			count := counts[number]
+			if count == 0 {
+				return number, true
+			}
+			if best == "" || count < bestCount {
+				best = number
+				bestCount = count
+			}
+		}
+		if limit == 0 || bestCount < limit {
+			return best, true
+		}
+	}
+	return "", false
+}
+
+// bucketWidth numbers in the bucket
+func (n NumberConfig) bucketWidth(bucket, width int) int {
+	low := n.Min + bucket*width
+	if low+width-1 > n.Max {
+		return n.Max - low + 1
+	}
+	return width
+}
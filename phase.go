@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// scheduleInterval how often the bot checks for a phase transition
+const scheduleInterval = 30 * time.Second
+
+func (b Bot) phase() Phase {
+	return b.campaign.Phase(time.Now())
+}
+
+// inPhase check if the campaign is in one of the phases
+func (b Bot) inPhase(phases ...Phase) bool {
+	current := b.phase()
+	for _, phase := range phases {
+		if phase == current {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPhase guard for handlers, tell the user why the command is not
+// available when the campaign is not in one of the phases
+func (b Bot) checkPhase(m *tb.Message, phases ...Phase) bool {
+	if b.inPhase(phases...) {
+		return true
+	}
+	message := ""
+	switch b.phase() {
+	case PhaseDraft:
+		message = "Chương trình chưa bắt đầu, con chờ thông báo của Bụt nhé."
+	case PhaseRegistration:
+		message = fmt.Sprintf("Phần trả lời câu hỏi chưa mở, con hãy mời bạn bè vào @%s trong lúc chờ nhé.", b.campaign.ChatGroup)
+	case PhaseQuiz:
+		message = "Lệnh này chưa dùng được lúc này."
+	default:
+		message = "Bụt rất tiếc, thời gian tham gia chương trình đã hết."
+	}
+	b.bot.Reply(m, message)
+	return false
+}
+
+func phaseMessage(phase Phase, campaign Campaign) string {
+	switch phase {
+	case PhaseRegistration:
+		return fmt.Sprintf("Chương trình của Bụt đã bắt đầu 🎉 Mời bạn bè vào @%s để nhận thêm vé may mắn nhé.", campaign.ChatGroup)
+	case PhaseQuiz:
+		return "Phần trả lời câu hỏi đã mở, chat /start riêng với Bụt để trả lời câu hỏi và nhận vé may mắn nhé."
+	case PhaseClosed:
+		return "Thời gian tham gia chương trình đã hết, Bụt không nhận thêm số may mắn nữa. Con chờ Bụt quay số nhé."
+	case PhaseDraw:
+		return "Đã đến giờ quay số may mắn, Bụt sẽ công bố kết quả ngay sau đây."
+	case PhaseFinished:
+		return "Chương trình đã kết thúc. Cảm ơn các con đã tham gia 🤗"
+	}
+	return ""
+}
+
+// schedule announce phase transitions in the chat group, the last announced
+// phase is stored so a restart doesn't announce it again
+func (b Bot) schedule() {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		b.announcePhase()
+		<-ticker.C
+	}
+}
+
+func (b Bot) announcePhase() {
+	phase := b.phase()
+	announced, err := b.storage.GetAnnouncedPhase()
+	if err != nil && err.Error() != "not found" {
+		log.Printf("Cannot get announced phase of %s: %s", b.campaign.ID, err.Error())
+		return
+	}
+	if phase == announced {
+		return
+	}
+	if message := phaseMessage(phase, b.campaign); message != "" {
+		b.announce(message)
+	}
+	if err := b.storage.UpdateAnnouncedPhase(phase); err != nil {
+		log.Printf("Cannot update announced phase of %s: %s", b.campaign.ID, err.Error())
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	// PoolLottery prizes awarded to the lucky numbers drawn
+	PoolLottery = "lottery"
+	// PoolTop prizes awarded to the users who invite most friends
+	PoolTop = "top"
+)
+
+// PrizeTier a group of identical prizes
+type PrizeTier struct {
+	Name     string  `json:"name"`
+	Count    int     `json:"count"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+	Pool     string  `json:"pool"`
+}
+
+// defaultPrizes used when the config has no prizes section
+var defaultPrizes = []PrizeTier{
+	{Name: "đặc biệt", Count: 5, Amount: 100, Currency: "KNC", Pool: PoolLottery},
+	{Name: "suýt đặc biệt", Count: 10, Amount: 10, Currency: "KNC", Pool: PoolLottery},
+	{Name: "cống hiến", Count: 5, Amount: 40, Currency: "KNC", Pool: PoolTop},
+}
+
+func (tier PrizeTier) String() string {
+	amount := strconv.FormatFloat(tier.Amount, 'f', -1, 64)
+	return fmt.Sprintf("%d giải \"%s\" mỗi giải %s %s", tier.Count, tier.Name, amount, tier.Currency)
+}
+
+// tiersOf prize tiers of a pool, in config order
+func tiersOf(prizes []PrizeTier, pool string) []PrizeTier {
+	result := []PrizeTier{}
+	for _, tier := range prizes {
+		if tier.Pool == pool {
+			result = append(result, tier)
+		}
+	}
+	return result
+}
+
+// countPrizes total number of prizes in the tiers
+func countPrizes(tiers []PrizeTier) int {
+	count := 0
+	for _, tier := range tiers {
+		count += tier.Count
+	}
+	return count
+}
+
+func validatePrizes(prizes []PrizeTier, numbers NumberConfig) error {
+	for index, tier := range prizes {
+		if tier.Name == "" {
+			return fmt.Errorf("prize %d: name is required", index)
+		}
+		if tier.Count <= 0 {
+			return fmt.Errorf("prize %q: count must be positive", tier.Name)
+		}
+		if tier.Pool != PoolLottery && tier.Pool != PoolTop {
+			return fmt.Errorf("prize %q: pool must be %q or %q", tier.Name, PoolLottery, PoolTop)
+		}
+	}
+	if countPrizes(tiersOf(prizes, PoolLottery)) > numbers.size() {
+		return fmt.Errorf("cannot draw more than %d lottery prizes", numbers.size())
+	}
+	return nil
+}
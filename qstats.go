@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// QuestionStats how a question did in the recorded attempts
+type QuestionStats struct {
+	ID       string
+	Question string
+	// Served attempts the question was drawn in
+	Served int
+	// Answered answers recorded, time outs included
+	Answered int
+	Correct  int
+	TimedOut int
+	// Options how many answers chose each option
+	Options []int
+	// MedianTime median seconds to answer, time outs excluded
+	MedianTime int64
+}
+
+// CorrectRate percent of the recorded answers which are correct
+func (s QuestionStats) CorrectRate() float64 {
+	if s.Answered == 0 {
+		return 0
+	}
+	return float64(s.Correct) * 100 / float64(s.Answered)
+}
+
+// questionStats stats of every question of the set, the attempts of every
+// version count since the questions are matched by id
+func questionStats(set QuestionSet, attempts []Attempt) []QuestionStats {
+	stats := []QuestionStats{}
+	byID := map[string]int{}
+	for index, question := range set.Questions {
+		byID[question.ID] = index
+		stats = append(stats, QuestionStats{
+			ID:       question.ID,
+			Question: question.Question,
+			Options:  make([]int, len(optionLetters)),
+		})
+	}
+	times := make([][]int64, len(set.Questions))
+	for _, attempt := range attempts {
+		for _, id := range attempt.Questions {
+			if index, ok := byID[id]; ok {
+				stats[index].Served++
+			}
+		}
+		for _, answer := range attempt.Answers {
+			index, ok := byID[answer.Question]
+			if !ok {
+				// removed from the questions file
+				continue
+			}
+			stat := &stats[index]
+			stat.Answered++
+			if answer.Correct {
+				stat.Correct++
+			}
+			if answer.TimedOut {
+				stat.TimedOut++
+				continue
+			}
+			for _, option := range answer.Options {
+				if option >= 0 && option < len(stat.Options) {
+					stat.Options[option]++
+				}
+			}
+			if answer.SentAt > 0 {
+				times[index] = append(times[index], answer.AnsweredAt-answer.SentAt)
+			}
+		}
+	}
+	for index := range stats {
+		stats[index].MedianTime = median(times[index])
+	}
+	return stats
+}
+
+func median(values []int64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i] < values[j]
+	})
+	middle := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[middle-1] + values[middle]) / 2
+	}
+	return values[middle]
+}
+
+// CampaignStats stats of the questions of a campaign
+type CampaignStats struct {
+	Campaign string
+	Version  int
+	Stats    []QuestionStats
+}
+
+// writeStatsCSV write the stats as csv, one row for each question of each campaign
+func writeStatsCSV(w io.Writer, campaigns []CampaignStats) error {
+	writer := csv.NewWriter(w)
+	header := []string{"campaign", "version", "id", "question", "served", "answered", "correct", "correct_rate", "timed_out"}
+	for _, letter := range optionLetters {
+		header = append(header, "option_"+letter)
+	}
+	header = append(header, "median_seconds")
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, campaign := range campaigns {
+		for _, stat := range campaign.Stats {
+			if err := writer.Write(statsRow(campaign, stat)); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func statsRow(campaign CampaignStats, stat QuestionStats) []string {
+	row := []string{
+		campaign.Campaign,
+		strconv.Itoa(campaign.Version),
+		stat.ID,
+		stat.Question,
+		strconv.Itoa(stat.Served),
+		strconv.Itoa(stat.Answered),
+		strconv.Itoa(stat.Correct),
+		strconv.FormatFloat(stat.CorrectRate(), 'f', 1, 64),
+		strconv.Itoa(stat.TimedOut),
+	}
+	for _, count := range stat.Options {
+		row = append(row, strconv.Itoa(count))
+	}
+	return append(row, strconv.FormatInt(stat.MedianTime, 10))
+}
+
+func statsMessage(campaign CampaignStats) string {
+	message := fmt.Sprintf("Thống kê bộ câu hỏi phiên bản %d (phục vụ / trả lời / tỉ lệ đúng / A-B-C-D / thời gian trung vị):\n", campaign.Version)
+	for _, stat := range campaign.Stats {
+		options := []string{}
+		for _, count := range stat.Options {
+			options = append(options, strconv.Itoa(count))
+		}
+		message += fmt.Sprintf("%s. %d / %d / %.0f%% / %s / %ds\n",
+			stat.ID, stat.Served, stat.Answered, stat.CorrectRate(), strings.Join(options, "-"), stat.MedianTime)
+	}
+	return message
+}
+
+// handleQStats /qstats [csv] show how every question did, as a csv file with csv
+func (b Bot) handleQStats(m *tb.Message) {
+	if !b.checkAdmin(m) {
+		return
+	}
+	attempts, err := b.storage.GetAllAttempts()
+	if err != nil {
+		log.Printf("Cannot get attempts of %s: %s", b.campaign.ID, err.Error())
+		b.bot.Send(m.Sender, "Không thể lấy các lượt trả lời, thử lại sau.")
+		return
+	}
+	set := b.questions.latest()
+	stats := CampaignStats{
+		Campaign: b.campaign.ID,
+		Version:  set.Version,
+		Stats:    questionStats(set, attempts),
+	}
+	if strings.TrimSpace(m.Payload) != "csv" {
+		b.bot.Send(m.Sender, statsMessage(stats))
+		return
+	}
+	file, err := ioutil.TempFile("", "qstats-"+b.campaign.ID+"-*.csv")
+	if err != nil {
+		log.Printf("Cannot create stats file: %s", err.Error())
+		return
+	}
+	defer os.Remove(file.Name())
+	err = writeStatsCSV(file, []CampaignStats{stats})
+	file.Close()
+	if err != nil {
+		log.Printf("Cannot write stats file: %s", err.Error())
+		return
+	}
+	_, err = b.bot.Send(m.Sender, &tb.Document{
+		File:     tb.FromDisk(file.Name()),
+		FileName: "qstats-" + b.campaign.ID + ".csv",
+		MIME:     "text/csv",
+	})
+	if err != nil {
+		log.Printf("Cannot send stats file: %s", err.Error())
+	}
+}
+
+// runQStats qstats [-campaign id] [-csv], report how every question of the
+// latest question set did, as csv on the standard output with -csv. The db is
+// opened read only, the bot must be stopped first
+func runQStats(args []string) error {
+	flags := flag.NewFlagSet("qstats", flag.ExitOnError)
+	campaignID := flags.String("campaign", "", "only report this campaign")
+	asCSV := flags.Bool("csv", false, "print csv")
+	flags.Parse(args)
+
+	botConfig, err := readConfigFromFile(configPath)
+	if err != nil {
+		return err
+	}
+	storage, err := NewReadOnlyStorage()
+	if err != nil {
+		return err
+	}
+	campaigns := []CampaignStats{}
+	for _, campaign := range botConfig.campaigns() {
+		if *campaignID != "" && campaign.ID != *campaignID {
+			continue
+		}
+		// the stats are of the question set the bot loaded last
+		set, err := storage.Campaign(campaign.ID).GetLatestQuestionSet()
+		if err != nil {
+			return fmt.Errorf("campaign %s: question set: %s", campaign.ID, err.Error())
+		}
+		attempts, err := storage.Campaign(campaign.ID).GetAllAttempts()
+		if err != nil {
+			return fmt.Errorf("campaign %s: %s", campaign.ID, err.Error())
+		}
+		campaigns = append(campaigns, CampaignStats{
+			Campaign: campaign.ID,
+			Version:  set.Version,
+			Stats:    questionStats(set, attempts),
+		})
+	}
+	if *asCSV {
+		return writeStatsCSV(os.Stdout, campaigns)
+	}
+	for _, campaign := range campaigns {
+		fmt.Printf("== %s ==\n%s\n", campaign.Campaign, statsMessage(campaign))
+	}
+	return nil
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// QuestionError problem found in a questions file
+type QuestionError struct {
+	Line    int
+	Index   int
+	Message string
+}
+
+func (e QuestionError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+	}
+	return fmt.Sprintf("line %d: question %d: %s", e.Line, e.Index+1, e.Message)
+}
+
+// QuestionErrors every problem found in a questions file
+type QuestionErrors []QuestionError
+
+func (errs QuestionErrors) Error() string {
+	messages := []string{}
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("%d problems:\n%s", len(errs), strings.Join(messages, "\n"))
+}
+
+// lineOf line number of a byte offset in data
+func lineOf(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// parseQuestions decode and validate a questions file, every invalid question
+// is reported with the line it starts at
+func parseQuestions(data []byte) (Questions, error) {
+	result := Questions{}
+	errs := QuestionErrors{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	syntaxError := func(err error) QuestionErrors {
+		line := lineOf(data, decoder.InputOffset())
+		if err, ok := err.(*json.SyntaxError); ok {
+			line = lineOf(data, err.Offset)
+		}
+		return append(errs, QuestionError{Line: line, Index: -1, Message: err.Error()})
+	}
+
+	token, err := decoder.Token()
+	if err != nil {
+		return result, syntaxError(err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return result, append(errs, QuestionError{Line: 1, Index: -1, Message: "questions must be a list"})
+	}
+	// line of the first question with each id
+	idLines := map[string]int{}
+	for index := 0; decoder.More(); index++ {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return result, syntaxError(err)
+		}
+		// the raw message ends at the decoder offset
+		line := lineOf(data, decoder.InputOffset()-int64(len(raw)))
+
+		var question QuizQuestion
+		strict := json.NewDecoder(bytes.NewReader(raw))
+		strict.DisallowUnknownFields()
+		if err := strict.Decode(&question); err != nil {
+			errs = append(errs, QuestionError{Line: line, Index: index, Message: err.Error()})
+			continue
+		}
+		for _, message := range question.problems() {
+			errs = append(errs, QuestionError{Line: line, Index: index, Message: message})
+		}
+		if first, ok := idLines[question.ID]; ok && question.ID != "" {
+			errs = append(errs, QuestionError{Line: line, Index: index, Message: fmt.Sprintf("id %q is repeated, first used at line %d", question.ID, first)})
+		} else {
+			idLines[question.ID] = line
+		}
+		result = append(result, question)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return result, syntaxError(err)
+	}
+	if len(errs) > 0 {
+		return result, errs
+	}
+	return result, nil
+}
+
+// QuestionType how a question is answered
+type QuestionType string
+
+const (
+	// TypeSingle choose the one correct option, the default
+	TypeSingle QuestionType = ""
+	// TypeMulti choose every correct option
+	TypeMulti QuestionType = "multi"
+	// TypeText type one of the accepted answers
+	TypeText QuestionType = "text"
+	// TypeNumeric type a number close enough to the answer
+	TypeNumeric QuestionType = "numeric"
+)
+
+// Difficulty how hard a question is
+type Difficulty string
+
+const (
+	// DifficultyEasy easy question
+	DifficultyEasy Difficulty = "easy"
+	// DifficultyMedium medium question, the default
+	DifficultyMedium Difficulty = "medium"
+	// DifficultyHard hard question
+	DifficultyHard Difficulty = "hard"
+)
+
+// difficulties every difficulty from the easiest
+var difficulties = []Difficulty{DifficultyEasy, DifficultyMedium, DifficultyHard}
+
+func (d Difficulty) valid() bool {
+	for _, difficulty := range difficulties {
+		if d == difficulty {
+			return true
+		}
+	}
+	return false
+}
+
+func (q QuizQuestion) difficulty() Difficulty {
+	if q.Difficulty == "" {
+		return DifficultyMedium
+	}
+	return q.Difficulty
+}
+
+// points earned by a fully correct answer
+func (q QuizQuestion) points() float64 {
+	if q.Points > 0 {
+		return q.Points
+	}
+	return 1
+}
+
+// byDifficulty indexes of the questions of each difficulty
+func (questions Questions) byDifficulty() map[Difficulty][]int {
+	result := map[Difficulty][]int{}
+	for index, question := range questions {
+		result[question.difficulty()] = append(result[question.difficulty()], index)
+	}
+	return result
+}
+
+// pickQuestions draw the questions of an attempt, with a mix the questions of
+// each difficulty are drawn on their own and then shuffled together
+func (b Bot) pickQuestions(questions Questions) []string {
+	picked := []int{}
+	if len(b.campaign.Quiz.Mix) == 0 {
+		picked = rand.Perm(len(questions))[:b.campaign.Quiz.Questions]
+	} else {
+		pool := questions.byDifficulty()
+		for _, difficulty := range difficulties {
+			indexes := pool[difficulty]
+			for _, i := range rand.Perm(len(indexes))[:b.campaign.Quiz.Mix[difficulty]] {
+				picked = append(picked, indexes[i])
+			}
+		}
+		rand.Shuffle(len(picked), func(i, j int) {
+			picked[i], picked[j] = picked[j], picked[i]
+		})
+	}
+	ids := []string{}
+	for _, index := range picked {
+		ids = append(ids, questions[index].ID)
+	}
+	return ids
+}
+
+// maxPoints points of the attempt with every answer correct
+func (b Bot) maxPoints(current Question) float64 {
+	total := 0.0
+	for position := 0; position < current.count(); position++ {
+		total += b.questionAt(current, position).points()
+	}
+	return total
+}
+
+// find the question with the id
+func (questions Questions) find(id string) (QuizQuestion, bool) {
+	for _, question := range questions {
+		if question.ID == id {
+			return question, true
+		}
+	}
+	return QuizQuestion{}, false
+}
+
+// typed check if the question is answered by typing rather than with the buttons
+func (q QuizQuestion) typed() bool {
+	return q.Type == TypeText || q.Type == TypeNumeric
+}
+
+// problems what is wrong with a question, the options are labelled A to D
+func (q QuizQuestion) problems() []string {
+	problems := []string{}
+	if strings.TrimSpace(q.ID) == "" {
+		problems = append(problems, "id is missing")
+	}
+	if strings.TrimSpace(q.Question) == "" {
+		problems = append(problems, "question is empty")
+	}
+	if q.Difficulty != "" && !q.Difficulty.valid() {
+		problems = append(problems, fmt.Sprintf("unknown difficulty %q, must be easy, medium or hard", q.Difficulty))
+	}
+	if q.Points < 0 {
+		problems = append(problems, fmt.Sprintf("points %g is negative", q.Points))
+	}
+	if q.Photo != "" && !isURL(q.Photo) {
+		if _, err := os.Stat(q.Photo); err != nil {
+			problems = append(problems, fmt.Sprintf("photo %s: %s", q.Photo, err.Error()))
+		}
+	}
+	switch q.Type {
+	case TypeSingle, TypeMulti:
+		problems = append(problems, q.optionProblems()...)
+	case TypeText:
+		if len(q.Options) > 0 {
+			problems = append(problems, "text question cannot have options")
+		}
+		if len(q.Accepted) == 0 {
+			problems = append(problems, "text question has no accepted answer")
+		}
+		for index, accepted := range q.Accepted {
+			if normalizeAnswer(accepted) == "" {
+				problems = append(problems, fmt.Sprintf("accepted answer %d is empty", index))
+			}
+		}
+	case TypeNumeric:
+		if len(q.Options) > 0 {
+			problems = append(problems, "numeric question cannot have options")
+		}
+		if q.Tolerance < 0 {
+			problems = append(problems, fmt.Sprintf("tolerance %g is negative", q.Tolerance))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown type %q, must be multi, text, numeric or empty", q.Type))
+	}
+	return problems
+}
+
+func (q QuizQuestion) optionProblems() []string {
+	problems := []string{}
+	if len(q.Options) < 2 || len(q.Options) > len(optionLetters) {
+		problems = append(problems, fmt.Sprintf("has %d options, must have 2 to %d", len(q.Options), len(optionLetters)))
+	}
+	for index, option := range q.Options {
+		if strings.TrimSpace(option) == "" {
+			problems = append(problems, fmt.Sprintf("option %d is empty", index))
+		}
+	}
+	if q.Type == TypeSingle {
+		if q.Answer < 0 || q.Answer >= len(q.Options) {
+			problems = append(problems, fmt.Sprintf("answer %d is not one of the options", q.Answer))
+		}
+		return problems
+	}
+	if len(q.Answers) == 0 {
+		problems = append(problems, "multi choice question has no answers")
+	}
+	seen := map[int]bool{}
+	for _, answer := range q.Answers {
+		if answer < 0 || answer >= len(q.Options) {
+			problems = append(problems, fmt.Sprintf("answer %d is not one of the options", answer))
+		}
+		if seen[answer] {
+			problems = append(problems, fmt.Sprintf("answer %d is repeated", answer))
+		}
+		seen[answer] = true
+	}
+	return problems
+}
+
+// gradeOption points of the chosen option of a single choice question
+func (q QuizQuestion) gradeOption(option int) float64 {
+	if option == q.Answer {
+		return q.points()
+	}
+	return 0
+}
+
+// gradeOptions points of the selected options of a multi choice question,
+// every correct option selected earns its share of the points and every wrong
+// one loses as much, down to 0
+func (q QuizQuestion) gradeOptions(selected []int) float64 {
+	answers := map[int]bool{}
+	for _, answer := range q.Answers {
+		answers[answer] = true
+	}
+	shares := 0
+	for _, option := range selected {
+		if answers[option] {
+			shares++
+		} else {
+			shares--
+		}
+	}
+	if shares <= 0 {
+		return 0
+	}
+	return q.points() * float64(shares) / float64(len(q.Answers))
+}
+
+// gradeText points of a typed answer, text answers ignore case, spacing and
+// Vietnamese diacritics, numeric answers may be off by the tolerance
+func (q QuizQuestion) gradeText(answer string) float64 {
+	if q.Type == TypeNumeric {
+		number, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(answer), ",", ".", 1), 64)
+		if err != nil || math.Abs(number-q.Number) > q.Tolerance {
+			return 0
+		}
+		return q.points()
+	}
+	answer = normalizeAnswer(answer)
+	for _, accepted := range q.Accepted {
+		if normalizeAnswer(accepted) == answer {
+			return q.points()
+		}
+	}
+	return 0
+}
+
+// vietnameseLetters base letter of the Vietnamese letters with a diacritic
+var vietnameseLetters = map[rune]string{
+	'a': "àáảãạăằắẳẵặâầấẩẫậ",
+	'e': "èéẻẽẹêềếểễệ",
+	'i': "ìíỉĩị",
+	'o': "òóỏõọôồốổỗộơờớởỡợ",
+	'u': "ùúủũụưừứửữự",
+	'y': "ỳýỷỹỵ",
+	'd': "đ",
+}
+
+var foldLetters = func() map[rune]rune {
+	letters := map[rune]rune{}
+	for base, marked := range vietnameseLetters {
+		for _, letter := range marked {
+			letters[letter] = base
+		}
+	}
+	return letters
+}()
+
+// normalizeAnswer lower case the answer, remove the diacritics and collapse the spaces
+func normalizeAnswer(answer string) string {
+	folded := []rune{}
+	for _, letter := range strings.ToLower(answer) {
+		if unicode.Is(unicode.Mn, letter) {
+			// combining marks of decomposed text
+			continue
+		}
+		if base, ok := foldLetters[letter]; ok {
+			letter = base
+		}
+		folded = append(folded, letter)
+	}
+	return strings.Join(strings.Fields(string(folded)), " ")
+}
+
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// photoFile file of a question photo, from an url or the local disk
+func photoFile(path string) tb.File {
+	if isURL(path) {
+		return tb.FromURL(path)
+	}
+	return tb.FromDisk(path)
+}
+
+// runValidate validate [file...], check the questions files, by default the
+// files of every configured campaign against the campaign quiz length
+func runValidate(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Parse(args)
+
+	type target struct {
+		path string
+		quiz *QuizConfig
+	}
+	targets := []target{}
+	for _, path := range flags.Args() {
+		targets = append(targets, target{path: path})
+	}
+	if len(targets) == 0 {
+		botConfig, err := readConfigFromFile(configPath)
+		if err != nil {
+			return err
+		}
+		for _, campaign := range botConfig.campaigns() {
+			if campaign.Questions == "" {
+				campaign.Questions = defaultQuestionsPath
+			}
+			if campaign.Quiz.Questions == 0 {
+				campaign.Quiz.Questions = campaign.Quiz.defaultQuestions()
+			}
+			quiz := campaign.Quiz
+			targets = append(targets, target{path: campaign.Questions, quiz: &quiz})
+		}
+	}
+
+	invalid := 0
+	for _, t := range targets {
+		data, err := ioutil.ReadFile(t.path)
+		if err != nil {
+			return err
+		}
+		questions, err := parseQuestions(data)
+		if err == nil && t.quiz != nil {
+			err = t.quiz.validate(questions)
+		}
+		if err != nil {
+			invalid++
+			fmt.Printf("%s: %s\n", t.path, err.Error())
+			continue
+		}
+		fmt.Printf("%s: %d questions OK\n", t.path, len(questions))
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d invalid questions files", invalid)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// QuestionSet a version of the questions file of a campaign, every attempt
+// keeps the version it started with so a reload doesn't change its questions
+type QuestionSet struct {
+	Version   int `storm:"id,increment"`
+	Hash      string
+	LoadedAt  int64
+	Questions Questions
+}
+
+// pinnedVersion question set version of an attempt, attempts started before
+// the question sets were versioned use the first version
+func pinnedVersion(version int) int {
+	if version == 0 {
+		return 1
+	}
+	return version
+}
+
+// questionSets the question sets of a campaign, shared by every copy of its bot
+type questionSets struct {
+	mu       sync.RWMutex
+	storage  *QuestionStorage
+	current  QuestionSet
+	versions map[int]Questions
+}
+
+func newQuestionSets(storage *QuestionStorage) (*questionSets, error) {
+	sets := &questionSets{
+		storage:  storage,
+		versions: map[int]Questions{},
+	}
+	latest, err := storage.GetLatestQuestionSet()
+	if err != nil && err.Error() != "not found" {
+		return sets, err
+	}
+	if err == nil {
+		sets.current = latest
+		sets.versions[latest.Version] = latest.Questions
+	}
+	return sets, nil
+}
+
+// latest question set new attempts start with
+func (s *questionSets) latest() QuestionSet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// version questions of a version, old versions are loaded from the storage
+// when an attempt still uses them
+func (s *questionSets) version(version int) Questions {
+	version = pinnedVersion(version)
+	s.mu.RLock()
+	questions, ok := s.versions[version]
+	s.mu.RUnlock()
+	if ok {
+		return questions
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set, err := s.storage.GetQuestionSet(version)
+	if err != nil {
+		log.Printf("Cannot get question set %d: %s", version, err.Error())
+		return s.current.Questions
+	}
+	s.versions[version] = set.Questions
+	return set.Questions
+}
+
+// reload validate the questions file and save it as a new version if it
+// changed, the current version is kept if the file is invalid
+func (s *questionSets) reload(path string, quiz QuizConfig) (QuestionSet, bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return s.latest(), false, err
+	}
+	questions, err := parseQuestions(data)
+	if err != nil {
+		return s.latest(), false, err
+	}
+	if err := quiz.validate(questions); err != nil {
+		return s.latest(), false, err
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if hash == s.current.Hash {
+		return s.current, false, nil
+	}
+	set := QuestionSet{
+		Hash:      hash,
+		LoadedAt:  time.Now().Unix(),
+		Questions: questions,
+	}
+	if err := s.storage.SaveQuestionSet(&set); err != nil {
+		return s.current, false, err
+	}
+	s.current = set
+	s.versions[set.Version] = questions
+	return set, true, nil
+}
+
+// questionsOf questions of the version the attempt started with
+func (b Bot) questionsOf(current Question) Questions {
+	return b.questions.version(current.Version)
+}
+
+// questionAt question at a position of the attempt, sessions started before
+// the questions had ids refer to them by index
+func (b Bot) questionAt(current Question, position int) QuizQuestion {
+	questions := b.questionsOf(current)
+	if len(current.QuestionIDs) == 0 {
+		return questions[current.Rands[position]]
+	}
+	question, ok := questions.find(current.QuestionIDs[position])
+	if !ok {
+		log.Printf("Cannot find question %s in version %d", current.QuestionIDs[position], pinnedVersion(current.Version))
+	}
+	return question
+}
+
+func (b Bot) reloadQuestions() (QuestionSet, bool, error) {
+	set, changed, err := b.questions.reload(b.campaign.Questions, b.campaign.Quiz)
+	if err != nil {
+		log.Printf("Cannot reload questions of %s: %s", b.campaign.ID, err.Error())
+	} else if changed {
+		log.Printf("Loaded questions of %s version %d: %d questions", b.campaign.ID, set.Version, len(set.Questions))
+	}
+	return set, changed, err
+}
+
+// handleReload /reload load the questions file again, attempts in progress keep their questions
+func (b Bot) handleReload(m *tb.Message) {
+	if !b.checkAdmin(m) {
+		return
+	}
+	set, changed, err := b.reloadQuestions()
+	if err != nil {
+		b.bot.Send(m.Sender, "Không thể nạp lại bộ câu hỏi, vẫn dùng bộ câu hỏi cũ:\n"+err.Error())
+		return
+	}
+	if !changed {
+		b.bot.Send(m.Sender, fmt.Sprintf("Bộ câu hỏi không thay đổi, đang dùng phiên bản %d (%d câu hỏi).", set.Version, len(set.Questions)))
+		return
+	}
+	b.bot.Send(m.Sender, fmt.Sprintf("Đã nạp bộ câu hỏi phiên bản %d (%d câu hỏi). Các lượt đang làm vẫn dùng bộ câu hỏi cũ.", set.Version, len(set.Questions)))
+}
+
+// reloadOnSignal reload the questions of every campaign on SIGHUP
+func reloadOnSignal(bots []Bot) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		for _, bot := range bots {
+			bot.reloadQuestions()
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// Router dispatch telegram updates to the bot of the campaign they belong to
+type Router struct {
+	bot     *tb.Bot
+	storage *QuestionStorage
+	bots    []Bot
+	locks   userLocks
+}
+
+// userLocks serialize updates of the same user, telebot runs every handler
+// in its own goroutine
+type userLocks struct {
+	mu    sync.Mutex
+	locks map[int]*userLock
+}
+
+type userLock struct {
+	sync.Mutex
+	holders int
+}
+
+// lock wait until no other update of the user is handled, the returned
+// function releases the lock
+func (l *userLocks) lock(userID int) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[int]*userLock{}
+	}
+	lock, ok := l.locks[userID]
+	if !ok {
+		lock = &userLock{}
+		l.locks[userID] = lock
+	}
+	lock.holders++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		lock.holders--
+		if lock.holders == 0 {
+			delete(l.locks, userID)
+		}
+		l.mu.Unlock()
+	}
+}
+
+func (r *Router) byID(id string) (Bot, bool) {
+	for _, bot := range r.bots {
+		if bot.campaign.ID == id {
+			return bot, true
+		}
+	}
+	return Bot{}, false
+}
+
+func (r *Router) byGroup(username string) (Bot, bool) {
+	bots := []Bot{}
+	for _, bot := range r.bots {
+		if bot.campaign.inGroup(username) {
+			bots = append(bots, bot)
+		}
+	}
+	return currentCampaign(bots, time.Now())
+}
+
+// route find the campaign of a message: group messages belong to the campaign
+// of the group, private messages to the campaign the user joined with /start
+// while it runs, then to the running campaign
+func (r *Router) route(m *tb.Message) (Bot, bool) {
+	if !m.Private() {
+		return r.byGroup(m.Chat.Username)
+	}
+	running := []Bot{}
+	for _, bot := range r.bots {
+		if bot.campaign.Running(time.Now()) {
+			running = append(running, bot)
+		}
+	}
+	participant, err := r.storage.GetParticipant(m.Sender.ID)
+	if err == nil {
+		// the joined campaign is kept when no other campaign runs, for /me after it ends
+		if bot, ok := r.byID(participant.CampaignID); ok && (bot.campaign.Running(time.Now()) || len(running) == 0) {
+			return bot, true
+		}
+	}
+	if len(running) > 1 {
+		message := "Bụt đang tổ chức nhiều chương trình, con muốn tham gia chương trình nào?\n"
+		for _, bot := range running {
+			message += fmt.Sprintf("/start %s - group @%s\n", bot.campaign.ID, bot.campaign.ChatGroup)
+		}
+		r.bot.Send(m.Chat, message)
+		return Bot{}, false
+	}
+	return currentCampaign(r.bots, time.Now())
+}
+
+func (r *Router) handle(endpoint interface{}, handler func(Bot, *tb.Message)) {
+	r.bot.Handle(endpoint, func(m *tb.Message) {
+		unlock := r.locks.lock(m.Sender.ID)
+		defer unlock()
+		bot, ok := r.route(m)
+		if !ok {
+			return
+		}
+		handler(bot, m)
+	})
+}
+
+// handleStart /start [campaign id] switch the campaign of the user before starting the quiz
+func (r *Router) handleStart(m *tb.Message) {
+	unlock := r.locks.lock(m.Sender.ID)
+	defer unlock()
+	payload := strings.TrimSpace(m.Payload)
+	if m.Private() && payload != "" {
+		if _, ok := r.byID(payload); !ok {
+			r.bot.Reply(m, fmt.Sprintf("Bụt không tìm thấy chương trình %s.", payload))
+			return
+		}
+		err := r.storage.UpdateParticipant(Participant{
+			ID:         m.Sender.ID,
+			CampaignID: payload,
+		})
+		if err != nil {
+			log.Printf("Cannot update participant: %s", err.Error())
+		}
+	}
+	bot, ok := r.route(m)
+	if !ok {
+		return
+	}
+	bot.handleStart(m)
+}
+
+// handleCallback route the callbacks of an inline button by the campaign in the
+// first field of its data, the handler gets the rest of the data in c.Data
+func (r *Router) handleCallback(endpoint tb.CallbackEndpoint, handler func(Bot, *tb.Callback)) {
+	r.bot.Handle(endpoint, func(c *tb.Callback) {
+		if c.Message == nil {
+			return
+		}
+		fields := strings.SplitN(c.Data, "|", 2)
+		bot, ok := r.byID(fields[0])
+		if !ok || len(fields) < 2 {
+			r.bot.Respond(c, &tb.CallbackResponse{
+				Text: "Chương trình này đã kết thúc.",
+			})
+			return
+		}
+		c.Data = fields[1]
+		unlock := r.locks.lock(c.Sender.ID)
+		defer unlock()
+		handler(bot, c)
+	})
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/coreos/bbolt"
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// stubSender answer every request to the telegram api with a message sent
+// by the bot, so handlers can send without network
+type stubSender struct {
+	sent int64
+}
+
+func (s *stubSender) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&s.sent, 1)
+	body := `{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"bot","username":"bot","message_id":1,"date":0,"chat":{"id":1,"type":"private"}}}`
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// idlePoller poller of a bot whose updates are pushed by the test
+type idlePoller struct{}
+
+func (idlePoller) Poll(b *tb.Bot, updates chan tb.Update, stop chan struct{}) {
+	<-stop
+	close(stop)
+}
+
+// openTestStorage storage in a temporary db removed after the test
+func openTestStorage(t testing.TB) *QuestionStorage {
+	log.SetOutput(ioutil.Discard)
+	dir, err := ioutil.TempDir("", "question_bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, err := openBoltStorage(filepath.Join(dir, "questions.db"), &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		storage.db.(interface{ Close() error }).Close()
+		os.RemoveAll(dir)
+		log.SetOutput(os.Stderr)
+	})
+	return storage
+}
+
+// testQuestions the questions of the test campaign, answered by the first
+// and the second option
+var testQuestions = Questions{
+	{ID: "q1", Question: "1+1?", Options: []string{"2", "3"}, Answer: 0},
+	{ID: "q2", Question: "2+2?", Options: []string{"3", "4"}, Answer: 1},
+}
+
+// newTestRouter router of a running campaign with a stub sender
+func newTestRouter(t *testing.T) (*Router, *stubSender) {
+	storage := openTestStorage(t)
+	sender := &stubSender{}
+	tbot, err := tb.NewBot(tb.Settings{
+		Token:   "test",
+		Updates: 1000,
+		Poller:  idlePoller{},
+		Client:  &http.Client{Transport: sender},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := &Router{
+		bot:     tbot,
+		storage: storage,
+	}
+	campaign := Campaign{
+		ID:        "test",
+		ChatGroup: "group",
+		Schedule: Schedule{
+			Registration: time.Now().Add(-time.Hour).Unix(),
+			Quiz:         time.Now().Add(-time.Hour).Unix(),
+			Closed:       time.Now().Add(time.Hour).Unix(),
+		},
+		Quiz:    QuizConfig{Questions: len(testQuestions), PassScore: len(testQuestions)},
+		Numbers: NumberConfig{Policy: PolicyUnique}.withDefaults(),
+	}
+	campaignStorage := storage.Campaign(campaign.ID)
+	router.bots = append(router.bots, Bot{
+		bot:      tbot,
+		storage:  campaignStorage,
+		campaign: campaign,
+		questions: &questionSets{
+			storage:  campaignStorage,
+			current:  QuestionSet{Version: 1, Questions: testQuestions},
+			versions: map[int]Questions{1: testQuestions},
+		},
+		locks: &router.locks,
+	})
+	go tbot.Start()
+	t.Cleanup(tbot.Stop)
+	return router, sender
+}
+
+// handled handler which tells wg when it is done, so the test knows when
+// every update it pushed is handled
+func handled(wg *sync.WaitGroup, handler func(Bot, *tb.Message)) func(Bot, *tb.Message) {
+	return func(b Bot, m *tb.Message) {
+		defer wg.Done()
+		handler(b, m)
+	}
+}
+
+func handledCallback(wg *sync.WaitGroup, handler func(Bot, *tb.Callback)) func(Bot, *tb.Callback) {
+	return func(b Bot, c *tb.Callback) {
+		defer wg.Done()
+		handler(b, c)
+	}
+}
+
+// hammer push the updates at once and wait until wg is done
+func hammer(t *testing.T, router *Router, wg *sync.WaitGroup, updates []tb.Update) {
+	wg.Add(len(updates))
+	for _, update := range updates {
+		router.bot.Updates <- update
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("handlers did not finish")
+	}
+}
+
+func privateUpdate(userID int, text string) tb.Update {
+	return tb.Update{
+		Message: &tb.Message{
+			Text:   text,
+			Sender: &tb.User{ID: userID, FirstName: "user" + strconv.Itoa(userID)},
+			Chat:   &tb.Chat{ID: int64(userID), Type: tb.ChatPrivate},
+		},
+	}
+}
+
+// answerUpdate tap on an answer button of the question sent to the user
+func answerUpdate(userID int, session string, index, option int) tb.Update {
+	return tb.Update{
+		Callback: &tb.Callback{
+			ID:     strconv.Itoa(userID),
+			Sender: &tb.User{ID: userID, FirstName: "user" + strconv.Itoa(userID)},
+			Message: &tb.Message{
+				ID:   1,
+				Chat: &tb.Chat{ID: int64(userID), Type: tb.ChatPrivate},
+			},
+			Data: fmt.Sprintf("\f%s|test|%s|%d|%d", answerButton.Unique, session, index, option),
+		},
+	}
+}
+
+// joinUpdate inviter adding a user to the group, the title of the group is
+// not cased as in the config
+func joinUpdate(inviterID, userID int) tb.Update {
+	user := tb.User{ID: userID, FirstName: "user" + strconv.Itoa(userID)}
+	return tb.Update{
+		Message: &tb.Message{
+			Sender:      &tb.User{ID: inviterID, FirstName: "user" + strconv.Itoa(inviterID)},
+			Chat:        &tb.Chat{ID: -1, Type: tb.ChatSuperGroup, Username: "Group"},
+			UserJoined:  &user,
+			UsersJoined: []tb.User{user},
+		},
+	}
+}
+
+// TestConcurrentUpdates hammer the answer buttons, the join events and the
+// lucky numbers through the handlers of the bot. Run it with the race
+// detector, see the README
+func TestConcurrentUpdates(t *testing.T) {
+	router, sender := newTestRouter(t)
+	storage := router.bots[0].storage
+	var wg sync.WaitGroup
+	router.handleCallback(&answerButton, handledCallback(&wg, Bot.handleAnswerCallback))
+	router.handle(tb.OnUserJoined, handled(&wg, Bot.handleUserJoined))
+	router.handle(tb.OnText, handled(&wg, Bot.handleText))
+
+	const users, taps = 10, 20
+	for user := 1; user <= users; user++ {
+		session := "s" + strconv.Itoa(user)
+		question := Question{ID: int64(user), QuestionIDs: []string{"q1", "q2"}, Session: session, Version: 1}
+		if err := storage.UpdateQuestion(question.ID, question); err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.StartAttempt(Attempt{UserID: user, Session: session, Questions: question.QuestionIDs}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// every user taps the right answer of each question many times, only
+	// the first tap of each question counts
+	for index, question := range testQuestions {
+		updates := []tb.Update{}
+		for i := 0; i < taps; i++ {
+			for user := 1; user <= users; user++ {
+				updates = append(updates, answerUpdate(user, "s"+strconv.Itoa(user), index, question.Answer))
+			}
+		}
+		hammer(t, router, &wg, updates)
+	}
+	for user := 1; user <= users; user++ {
+		score, err := storage.GetUserScore(user)
+		if err != nil || score.Score != len(testQuestions) || len(score.Results) != len(testQuestions) {
+			t.Errorf("user %d: %d results, want %d: %+v %v", user, len(score.Results), len(testQuestions), score, err)
+		}
+		attempts, _ := storage.GetAttempts(user)
+		if len(attempts) != 1 || len(attempts[0].Answers) != len(testQuestions) {
+			t.Errorf("user %d: attempts %+v, want one with %d answers", user, attempts, len(testQuestions))
+		}
+	}
+
+	// inviters 100.. add the same user 1000 and one user of their own, every
+	// join event arrives several times
+	const inviters, duplicates = 10, 5
+	updates := []tb.Update{}
+	for i := 0; i < duplicates; i++ {
+		for inviter := 100; inviter < 100+inviters; inviter++ {
+			updates = append(updates, joinUpdate(inviter, 1000), joinUpdate(inviter, inviter+1000))
+		}
+	}
+	hammer(t, router, &wg, updates)
+
+	invites, _ := storage.GetAllInvitedUser()
+	if len(invites) != inviters+1 {
+		t.Errorf("%d invites, want %d", len(invites), inviters+1)
+	}
+	tops, _ := storage.GetTop()
+	points := 0
+	for _, top := range tops {
+		points += top.Point
+	}
+	if points != inviters+1 {
+		t.Errorf("tops have %d points, want %d", points, inviters+1)
+	}
+	tickets, _ := storage.GetAllTickets()
+	active := 0
+	for _, ticket := range tickets {
+		if ticket.Source == TicketInvite && ticket.active() {
+			active++
+		}
+	}
+	if active != inviters+1 {
+		t.Errorf("%d active invite tickets, want %d", active, inviters+1)
+	}
+
+	// the users passed the quiz and are asked for a lucky number, all of
+	// them send the same one at once, then one of their own
+	updates = []tb.Update{}
+	for i := 0; i < duplicates; i++ {
+		for user := 1; user <= users; user++ {
+			updates = append(updates, privateUpdate(user, "0042"))
+		}
+	}
+	hammer(t, router, &wg, updates)
+	updates = []tb.Update{}
+	for user := 1; user <= users; user++ {
+		updates = append(updates, privateUpdate(user, fmt.Sprintf("%04d", 5000+user)))
+	}
+	hammer(t, router, &wg, updates)
+
+	tickets, _ = storage.GetAllTickets()
+	numbers := map[string]int{}
+	quiz := 0
+	for _, ticket := range tickets {
+		if ticket.Source == TicketQuiz {
+			numbers[ticket.Number]++
+			quiz++
+		}
+	}
+	if quiz != users {
+		t.Errorf("%d quiz tickets, want %d", quiz, users)
+	}
+	if numbers["0042"] != 1 {
+		t.Errorf("unique number 0042 picked %d times, want once", numbers["0042"])
+	}
+	if len(numbers) != users || numbers[""] != 0 {
+		t.Errorf("quiz ticket numbers %v, want %d different ones", numbers, users)
+	}
+	if atomic.LoadInt64(&sender.sent) == 0 {
+		t.Error("nothing sent through the stub sender")
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// ticketsPerMessage tickets listed in one message of /me
+const ticketsPerMessage = 100
+
+// pickButton endpoint of the buttons which let the bot pick the number, the
+// callback data is "campaign|one" or "campaign|all"
+var pickButton = tb.InlineButton{Unique: "pick"}
+
+const (
+	pickOne = "one"
+	pickAll = "all"
+)
+
+// pickRetries numbers tried when another user takes the picked number first
+const pickRetries = 3
+
+// openTickets count the tickets which can still pick a number
+func openTickets(tickets []Ticket) int {
+	count := 0
+	for _, ticket := range tickets {
+		if ticket.open() {
+			count++
+		}
+	}
+	return count
+}
+
+// String one line about the ticket for /me and /stat
+func (t Ticket) String() string {
+	text := ""
+	switch t.Source {
+	case TicketQuiz:
+		text = "Vé trả lời câu hỏi"
+	case TicketInvite:
+		text = fmt.Sprintf("Vé mời [%s](tg://user?id=%d)", t.Reason, t.Ref)
+	case TicketBonus:
+		text = "Vé thưởng"
+		if t.Reason != "" {
+			text += " (" + t.Reason + ")"
+		}
+	}
+	if t.Number == "" {
+		text += ", chưa chọn số"
+	} else {
+		text += ", số may mắn: " + t.Number
+	}
+	if len(t.Changes) > 0 {
+		text += fmt.Sprintf(", đã đổi số %d lần", len(t.Changes))
+	}
+	if !t.active() {
+		text += ", đã bị hủy"
+		if t.RevokedReason != "" {
+			text += " vì " + t.RevokedReason
+		}
+	} else if !t.Valid {
+		text += ", không được tính"
+	}
+	return text
+}
+
+// ticketsText list the tickets, split into messages of ticketsPerMessage lines
+func ticketsText(tickets []Ticket) []string {
+	messages := []string{}
+	for index, ticket := range tickets {
+		if index%ticketsPerMessage == 0 {
+			messages = append(messages, "")
+		}
+		messages[len(messages)-1] += ticket.String() + "\n"
+	}
+	return messages
+}
+
+// issueQuizTicket give the ticket of the quiz once, it returns how many open
+// tickets the user has
+func (b Bot) issueQuizTicket(m *tb.Message, score Score) int {
+	_, err := b.storage.IssueTicket(Ticket{
+		Owner:     m.Sender.ID,
+		OwnerName: fmt.Sprintf("%s %s", m.Sender.FirstName, m.Sender.LastName),
+		Source:    TicketQuiz,
+		Ref:       m.Sender.ID,
+		Reason:    fmt.Sprintf("đúng %d/%d câu", score.Score, b.campaign.Quiz.Questions),
+	})
+	if err != nil && err != ErrTicketIssued {
+		log.Printf("Cannot issue quiz ticket: %s", err.Error())
+	}
+	tickets, err := b.storage.GetTickets(m.Sender.ID)
+	if err != nil {
+		log.Printf("Cannot get tickets: %s", err.Error())
+	}
+	return openTickets(tickets)
+}
+
+// fillTicket pick the lucky number with an open ticket of the sender
+func (b Bot) fillTicket(m *tb.Message, lucky string) {
+	_, remaining, err := b.storage.FillTicket(m.Sender.ID, lucky, b.campaign.Numbers.limit())
+	if err == ErrNumberTaken {
+		b.updateState(m, StateAwaitingLucky, "")
+		b.bot.Send(m.Chat, fmt.Sprintf("Số %s đã có người chọn rồi, con chọn số khác nhé.", lucky))
+		return
+	}
+	if err == ErrNoTicket {
+		b.bot.Send(m.Chat, "Con không còn vé nào để chọn số may mắn.")
+		return
+	}
+	if err != nil {
+		log.Printf("Cannot update lucky number: %s", err.Error())
+		b.bot.Send(m.Chat, "Bụt chưa lưu được số may mắn, con thử lại sau nhé.")
+		return
+	}
+	message := fmt.Sprintf("Số may mắn con đã chọn là: %s, Bụt sẽ quay số may mắn và thông báo người trúng thưởng khi chương trình kết thúc nhé. ", lucky)
+	if remaining > 0 {
+		message += fmt.Sprintf("Con còn %d vé, /add để chọn số may mắn nhé.", remaining)
+	}
+	b.bot.Send(m.Chat, message)
+}
+
+// pickOptions buttons of the number prompt to let the bot pick the number of
+// one ticket, or of every open ticket when there are more
+func (b Bot) pickOptions(open int) *tb.SendOptions {
+	keys := []tb.InlineButton{
+		{
+			Unique: pickButton.Unique,
+			Text:   "Chọn giúp con",
+			Data:   b.callbackData(pickOne),
+		},
+	}
+	if open > 1 {
+		keys = append(keys, tb.InlineButton{
+			Unique: pickButton.Unique,
+			Text:   fmt.Sprintf("Chọn giúp cả %d vé", open),
+			Data:   b.callbackData(pickAll),
+		})
+	}
+	return &tb.SendOptions{
+		ReplyMarkup: &tb.ReplyMarkup{
+			InlineKeyboard: [][]tb.InlineButton{keys},
+		},
+	}
+}
+
+// pickTicket fill the oldest open ticket of the owner with a number picked
+// from the least crowded part of the number space, counts are the tickets of
+// each number and are updated with the pick
+func (b Bot) pickTicket(owner int, counts map[string]int) (Ticket, int, error) {
+	limit := b.campaign.Numbers.limit()
+	for i := 0; i < pickRetries; i++ {
+		number, ok := b.campaign.Numbers.pick(counts)
+		if !ok {
+			return Ticket{}, 0, ErrNumberTaken
+		}
+		ticket, remaining, err := b.storage.FillTicket(owner, number, limit)
+		if err == ErrNumberTaken {
+			// someone else took it since the counts were read
+			counts[number] = limit
+			continue
+		}
+		if err == nil {
+			counts[number]++
+		}
+		return ticket, remaining, err
+	}
+	return Ticket{}, 0, ErrNumberTaken
+}
+
+func (b Bot) handlePickCallback(c *tb.Callback) {
+	m := callbackMessage(c)
+	if !b.checkPhase(m, PhaseRegistration, PhaseQuiz) {
+		b.bot.Respond(c)
+		return
+	}
+	b.bot.Respond(c)
+	numbers := []string{}
+	remaining := 0
+	// the counts are read once for all the tickets picked
+	counts, err := b.storage.NumberCounts()
+	for err == nil {
+		ticket, left, pickErr := b.pickTicket(c.Sender.ID, counts)
+		if pickErr != nil {
+			err = pickErr
+			break
+		}
+		numbers = append(numbers, ticket.Number)
+		remaining = left
+		if c.Data != pickAll || remaining == 0 {
+			break
+		}
+	}
+	if err != nil && err != ErrNoTicket {
+		log.Printf("Cannot pick number: %s", err.Error())
+	}
+	if len(numbers) == 0 {
+		switch err {
+		case ErrNoTicket:
+			b.bot.Send(m.Chat, "Con không còn vé nào để chọn số may mắn.")
+		case ErrNumberTaken:
+			b.bot.Send(m.Chat, "Tất cả các số đã có người chọn, Bụt không chọn giúp con được nữa.")
+		default:
+			b.bot.Send(m.Chat, "Bụt chưa chọn được số, con thử lại sau nhé.")
+		}
+		return
+	}
+	if remaining == 0 {
+		b.updateState(m, StateIdle, "")
+	} else {
+		b.updateState(m, StateAwaitingLucky, "")
+	}
+	message := fmt.Sprintf("Bụt đã chọn giúp con số may mắn: %s. Bụt sẽ quay số may mắn và thông báo người trúng thưởng khi chương trình kết thúc nhé. ", strings.Join(numbers, ", "))
+	if remaining > 0 {
+		message += fmt.Sprintf("Con còn %d vé, nhập %s hoặc để Bụt chọn giúp tiếp nhé.", remaining, b.campaign.Numbers.describe())
+		b.bot.Send(m.Chat, message, b.pickOptions(remaining))
+		return
+	}
+	b.bot.Send(m.Chat, message)
+}
+
+// handleBonus /bonus [user id] [reason] give a user one more ticket
+func (b Bot) handleBonus(m *tb.Message) {
+	if !b.checkAdmin(m) {
+		return
+	}
+	fields := strings.Fields(m.Payload)
+	if len(fields) == 0 {
+		b.bot.Send(m.Sender, "Sử dụng cú pháp /bonus [user id] [lý do] để tặng thêm vé cho người dùng.")
+		return
+	}
+	userID, err := strconv.Atoi(fields[0])
+	if err != nil {
+		b.bot.Send(m.Sender, "Sử dụng cú pháp /bonus [user id] [lý do] để tặng thêm vé cho người dùng.")
+		return
+	}
+	score, err := b.storage.GetUserScore(userID)
+	if err != nil {
+		b.bot.Send(m.Sender, "Người dùng này chưa tham gia trả lời câu hỏi.")
+		return
+	}
+	reason := strings.Join(fields[1:], " ")
+	_, err = b.storage.IssueTicket(Ticket{
+		Owner:     userID,
+		OwnerName: fmt.Sprintf("%s %s", score.FirstName, score.LastName),
+		Source:    TicketBonus,
+		Ref:       userID,
+		Reason:    reason,
+	})
+	if err != nil {
+		b.bot.Send(m.Sender, "Không thể tặng vé, thử lại sau.")
+		return
+	}
+	b.bot.Send(m.Sender, fmt.Sprintf("Đã tặng thêm 1 vé cho người dùng %d.", userID))
+	b.bot.Send(&tb.User{ID: userID}, "Bụt tặng con thêm 1 vé may mắn, /add để chọn số may mắn nhé.")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	tb "gopkg.in/tucnak/telebot.v2"
+)
+
+// timerInterval how often the bot times out questions and updates the countdowns
+const timerInterval = 5 * time.Second
+
+// questionDeadline when the question sent now times out, 0 if there is no limit
+func (b Bot) questionDeadline(current Question) int64 {
+	deadline := int64(0)
+	if b.campaign.Quiz.QuestionTimeLimit > 0 {
+		deadline = time.Now().Unix() + int64(b.campaign.Quiz.QuestionTimeLimit)
+	}
+	if current.QuizDeadline > 0 && (deadline == 0 || current.QuizDeadline < deadline) {
+		deadline = current.QuizDeadline
+	}
+	return deadline
+}
+
+func countdownText(deadline int64) string {
+	remaining := deadline - time.Now().Unix()
+	if remaining < 0 {
+		remaining = 0
+	}
+	return fmt.Sprintf("\n⏳ Còn %d giây", remaining)
+}
+
+// privateMessage message of a user in the private chat with the bot, for
+// handlers run by the timer rather than by an update
+func privateMessage(userID int) *tb.Message {
+	return &tb.Message{
+		Sender: &tb.User{ID: userID},
+		Chat: &tb.Chat{
+			ID:   int64(userID),
+			Type: tb.ChatPrivate,
+		},
+	}
+}
+
+// watchTimers time out the questions past their deadline and update the
+// countdown of the others, the deadlines are stored so they survive a restart
+func (b Bot) watchTimers() {
+	ticker := time.NewTicker(timerInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		questions, err := b.storage.GetTimedQuestions()
+		if err != nil {
+			log.Printf("Cannot get timed questions of %s: %s", b.campaign.ID, err.Error())
+			continue
+		}
+		for _, question := range questions {
+			if time.Now().Unix() >= question.Deadline {
+				b.timeout(question)
+			} else {
+				b.countdown(question)
+			}
+		}
+	}
+}
+
+func (b Bot) countdown(current Question) {
+	unlock := b.locks.lock(int(current.ID))
+	defer unlock()
+	// the user may have answered since the timed questions were loaded
+	latest, err := b.storage.GetCurrentQuestion(current.ID)
+	if err != nil || latest.Deadline != current.Deadline || latest.CurrentQuestion != current.CurrentQuestion {
+		return
+	}
+	question := b.shownQuestion(current, current.CurrentQuestion)
+	text := questionText(current.CurrentQuestion, question) + countdownText(current.Deadline)
+	if err := b.editQuestion(latest, question, text, b.questionKeys(latest, question)); err != nil {
+		log.Printf("Cannot update countdown: %s", err.Error())
+	}
+}
+
+// timeout count the current question as wrong and send the next one, or
+// finish the quiz if its time is over
+func (b Bot) timeout(current Question) {
+	m := privateMessage(int(current.ID))
+	unlock := b.locks.lock(m.Sender.ID)
+	defer unlock()
+
+	quizOver := current.QuizDeadline > 0 && time.Now().Unix() >= current.QuizDeadline
+	err := b.storage.TimeoutQuestion(current.ID, current.CurrentQuestion, quizOver, b.attemptAnswer(current, 0))
+	if err == ErrStaleAnswer {
+		return
+	}
+	if err != nil {
+		log.Printf("Cannot time out question: %s", err.Error())
+		return
+	}
+	question := b.shownQuestion(current, current.CurrentQuestion)
+	text := questionText(current.CurrentQuestion, question) + "\n⌛️ Hết giờ, câu này con chưa trả lời."
+	if quizOver {
+		text += " Đã hết thời gian làm bài."
+	}
+	if err := b.editQuestion(current, question, text, nil); err != nil {
+		log.Printf("Cannot edit question message: %s", err.Error())
+	}
+	b.next(m)
+}
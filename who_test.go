@@ -0,0 +1,167 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// whoBatch tickets saved in one transaction while generating the db
+const whoBatch = 1000
+
+// generateTickets save tickets of random numbers, some of them revoked or of
+// users who left the group so the lookups have to skip them
+func generateTickets(t testing.TB, storage *QuestionStorage, numbers NumberConfig, count int, random *rand.Rand) {
+	for saved := 0; saved < count; {
+		tx, err := storage.db.Begin(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < whoBatch && saved < count; i, saved = i+1, saved+1 {
+			ticket := Ticket{
+				Owner:     saved + 1,
+				OwnerName: "user " + strconv.Itoa(saved+1),
+				Source:    TicketBonus,
+				Number:    numbers.format(numbers.Min + random.Intn(numbers.size())),
+				Valid:     random.Intn(20) != 0,
+			}
+			if random.Intn(20) == 0 {
+				ticket.RevokedAt = time.Now().Unix()
+			}
+			if err := tx.Save(&ticket); err != nil {
+				tx.Rollback()
+				t.Fatal(err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// scanWho what Who finds, by loading every ticket
+func scanWho(storage *QuestionStorage, lucky string) ([]User, error) {
+	target, err := strconv.Atoi(lucky)
+	if err != nil {
+		return nil, err
+	}
+	tickets, err := storage.GetAllTickets()
+	if err != nil {
+		return nil, err
+	}
+	best := -1
+	counted := []Ticket{}
+	for _, ticket := range tickets {
+		if !ticket.active() || !ticket.Valid || ticket.Number == "" {
+			continue
+		}
+		value, _ := strconv.Atoi(ticket.Number)
+		distance := Abs(value - target)
+		if best < 0 || distance < best {
+			best = distance
+		}
+		counted = append(counted, ticket)
+	}
+	// lower number first then by ticket, as Who
+	sort.Slice(counted, func(i, j int) bool {
+		if counted[i].Number != counted[j].Number {
+			return counted[i].Number < counted[j].Number
+		}
+		return counted[i].ID < counted[j].ID
+	})
+	result := []User{}
+	for _, ticket := range counted {
+		value, _ := strconv.Atoi(ticket.Number)
+		if Abs(value-target) == best {
+			result = append(result, NewUser(ticket.Owner, ticket.OwnerName, ticket.Number))
+		}
+	}
+	return result, nil
+}
+
+// TestWhoMatchesScan Who finds the same people as a scan of every ticket
+func TestWhoMatchesScan(t *testing.T) {
+	storage := openTestStorage(t).Campaign("who")
+	numbers := NumberConfig{}.withDefaults()
+	random := rand.New(rand.NewSource(1))
+	generateTickets(t, storage, numbers, 3000, random)
+
+	targets := []string{numbers.format(numbers.Min), numbers.format(numbers.Max)}
+	for i := 0; i < 200; i++ {
+		targets = append(targets, numbers.format(numbers.Min+random.Intn(numbers.size())))
+	}
+	for _, target := range targets {
+		indexed, err := storage.Who(target)
+		if err != nil {
+			t.Fatalf("who %s: %s", target, err)
+		}
+		scanned, err := scanWho(storage, target)
+		if err != nil {
+			t.Fatalf("scan %s: %s", target, err)
+		}
+		if !reflect.DeepEqual(indexed, scanned) {
+			t.Errorf("who %s: index %v, scan %v", target, indexed, scanned)
+		}
+	}
+}
+
+// BenchmarkWho lookups of random numbers among 50000 tickets
+func BenchmarkWho(b *testing.B) {
+	storage := openTestStorage(b).Campaign("who")
+	numbers := NumberConfig{}.withDefaults()
+	random := rand.New(rand.NewSource(1))
+	generateTickets(b, storage, numbers, 50000, random)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		target := numbers.format(numbers.Min + random.Intn(numbers.size()))
+		if _, err := storage.Who(target); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestWhoIDWithSeparator ticket ids whose 8 bytes contain "__" (0x5F5F) are
+// still found by their number
+func TestWhoIDWithSeparator(t *testing.T) {
+	storage := openTestStorage(t).Campaign("who")
+	tickets := []Ticket{
+		{ID: 24415, Owner: 1, OwnerName: "one", Number: "0500", Valid: true},
+		{ID: 89951, Owner: 2, OwnerName: "two", Number: "0500", Valid: true},
+		{ID: 3, Owner: 3, OwnerName: "three", Number: "0510", Valid: true},
+	}
+	for i := range tickets {
+		if err := storage.db.Save(&tickets[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	both := []User{NewUser(1, "one", "0500"), NewUser(2, "two", "0500")}
+	cases := []struct {
+		lucky string
+		want  []User
+	}{
+		{"0500", both},
+		{"0502", both},
+		{"0495", both},
+		{"0507", []User{NewUser(3, "three", "0510")}},
+	}
+	for _, c := range cases {
+		users, err := storage.Who(c.lucky)
+		if err != nil {
+			t.Fatalf("who %s: %s", c.lucky, err)
+		}
+		if !reflect.DeepEqual(users, c.want) {
+			t.Errorf("who %s: %+v, want %+v", c.lucky, users, c.want)
+		}
+	}
+	counts, err := storage.NumberCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"0500": 2, "0510": 1}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("counts %v, want %v", counts, want)
+	}
+}